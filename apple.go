@@ -0,0 +1,125 @@
+package enmime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"path/filepath"
+)
+
+// AppleSingle and AppleDouble (RFC 1740) carry the data fork, resource fork and Finder
+// metadata of Macintosh files.  multipart/appledouble wraps an application/applefile part
+// holding everything but the data fork, followed by the data fork itself.
+
+const (
+	appleSingleMagic = 0x00051600
+	appleDoubleMagic = 0x00051607
+
+	appleEntryDataFork = 1
+	appleEntryRealName = 3
+)
+
+// IsAppleResourceFork returns true if p is the application/applefile half of a
+// multipart/appledouble pair, holding the resource fork and Finder metadata of the
+// attachment in its sibling data fork part.
+func IsAppleResourceFork(p MIMEPart) bool {
+	return p.ContentType() == "application/applefile" && p.Parent() != nil &&
+		p.Parent().ContentType() == "multipart/appledouble"
+}
+
+// parseAppleEntries returns the entries of an AppleSingle or AppleDouble file keyed by
+// entry ID, along with the file's magic number.
+func parseAppleEntries(data []byte) (map[uint32][]byte, uint32, error) {
+	be := binary.BigEndian
+	if len(data) < 26 {
+		return nil, 0, fmt.Errorf("AppleSingle header truncated")
+	}
+	magic := be.Uint32(data)
+	if magic != appleSingleMagic && magic != appleDoubleMagic {
+		return nil, 0, fmt.Errorf("Invalid AppleSingle magic number %#x", magic)
+	}
+	count := int(be.Uint16(data[24:]))
+	if 26+12*count > len(data) {
+		return nil, 0, fmt.Errorf("AppleSingle entry table truncated")
+	}
+	entries := make(map[uint32][]byte, count)
+	for i := 0; i < count; i++ {
+		e := data[26+12*i:]
+		id, off, length := be.Uint32(e), be.Uint32(e[4:]), be.Uint32(e[8:])
+		if uint64(off)+uint64(length) > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("AppleSingle entry %v out of range", id)
+		}
+		entries[id] = data[off : off+length]
+	}
+	return entries, magic, nil
+}
+
+// decodeAppleSingle replaces the content of an application/applesingle part with its data
+// fork, taking the file name and content type from the embedded metadata.  The Content-Type
+// header is rewritten to match, so the part is written as the file it now holds.  If data
+// cannot be decoded it is returned unchanged.
+func decodeAppleSingle(p *memMIMEPart, data []byte) []byte {
+	entries, magic, err := parseAppleEntries(data)
+	if err != nil || magic != appleSingleMagic {
+		return data
+	}
+	if p.fileName == "" {
+		p.fileName = string(entries[appleEntryRealName])
+	}
+	p.contentType = "application/octet-stream"
+	if ctype := mime.TypeByExtension(filepath.Ext(p.fileName)); ctype != "" {
+		p.contentType, _, _ = mime.ParseMediaType(ctype)
+	}
+
+	// Keep the other parameters, the parser copied them into the header as well
+	_, params, err := mime.ParseMediaType(p.header.Get("Content-Type"))
+	if err != nil {
+		params = make(map[string]string)
+	}
+	if p.fileName != "" {
+		params["name"] = p.fileName
+	}
+	p.header.Set("Content-Type", mime.FormatMediaType(p.contentType, params))
+	return entries[appleEntryDataFork]
+}
+
+// fixAppleDouble makes the data fork of a multipart/appledouble part stand in for the whole
+// file: it inherits the wrapper's disposition and the real file name, and gets a content
+// type guessed from that name if it has none of its own.
+func fixAppleDouble(p *memMIMEPart) {
+	var resource, data *memMIMEPart
+	for c := p.firstChild; c != nil; c = c.NextSibling() {
+		mp := c.(*memMIMEPart)
+		if IsAppleResourceFork(mp) {
+			resource = mp
+		} else if data == nil {
+			data = mp
+		}
+	}
+	if data == nil {
+		return
+	}
+
+	if data.disposition == "" {
+		data.disposition = p.disposition
+		if data.disposition == "" {
+			data.disposition = "attachment"
+		}
+	}
+	p.disposition = ""
+	if data.fileName == "" {
+		data.fileName = p.fileName
+	}
+	if data.fileName == "" && resource != nil {
+		entries, _, err := parseAppleEntries(resource.Content())
+		if err == nil {
+			data.fileName = string(entries[appleEntryRealName])
+		}
+	}
+	if data.contentType == "application/octet-stream" || data.contentType == "" {
+		ctype := mime.TypeByExtension(filepath.Ext(data.fileName))
+		if mediatype, _, err := mime.ParseMediaType(ctype); err == nil {
+			data.contentType = mediatype
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// buildAppleFile returns an AppleSingle or AppleDouble file holding the given entries, in
+// order of entry ID
+func buildAppleFile(magic uint32, entries map[uint32]string) []byte {
+	be := binary.BigEndian
+	var ids []uint32
+	for id := uint32(1); id <= 15; id++ {
+		if _, ok := entries[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	var b bytes.Buffer
+	binary.Write(&b, be, magic)
+	binary.Write(&b, be, uint32(0x00020000))
+	b.Write(make([]byte, 16))
+	binary.Write(&b, be, uint16(len(ids)))
+	off := uint32(26 + 12*len(ids))
+	for _, id := range ids {
+		binary.Write(&b, be, []uint32{id, off, uint32(len(entries[id]))})
+		off += uint32(len(entries[id]))
+	}
+	for _, id := range ids {
+		b.WriteString(entries[id])
+	}
+	return b.Bytes()
+}
+
+func TestParseAppleEntries(t *testing.T) {
+	single := buildAppleFile(appleSingleMagic, map[uint32]string{
+		appleEntryDataFork: "data",
+		appleEntryRealName: "photo.png",
+	})
+	badMagic := append([]byte(nil), single...)
+	binary.BigEndian.PutUint32(badMagic, 0x12345678)
+	badEntry := append([]byte(nil), single...)
+	binary.BigEndian.PutUint32(badEntry[26+8:], 1000)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"AppleSingle", single, false},
+		{"AppleDouble", buildAppleFile(appleDoubleMagic, map[uint32]string{3: "a"}), false},
+		{"empty", nil, true},
+		{"truncated header", single[:25], true},
+		{"truncated entry table", single[:30], true},
+		{"truncated entry", single[:len(single)-1], true},
+		{"bad magic", badMagic, true},
+		{"entry out of range", badEntry, true},
+	}
+	for _, tt := range tests {
+		entries, _, err := parseAppleEntries(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: parseAppleEntries() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.name == "AppleSingle" && (string(entries[appleEntryDataFork]) != "data" ||
+			string(entries[appleEntryRealName]) != "photo.png") {
+			t.Errorf("%v: parseAppleEntries() = %q", tt.name, entries)
+		}
+	}
+}
+
+func TestParseAppleSingle(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctype    string
+		file     []byte
+		wantType string
+		wantName string
+		wantBody string
+		wantCT   string
+	}{
+		{
+			name:  "named by metadata",
+			ctype: "application/applesingle; x-mac-type=504E4766",
+			file: buildAppleFile(appleSingleMagic, map[uint32]string{
+				appleEntryDataFork: "PNG data",
+				appleEntryRealName: "photo.png",
+			}),
+			wantType: "image/png",
+			wantName: "photo.png",
+			wantBody: "PNG data",
+			wantCT:   "image/png; name=photo.png; x-mac-type=504E4766",
+		},
+		{
+			name:  "unknown extension",
+			ctype: "application/applesingle",
+			file: buildAppleFile(appleSingleMagic, map[uint32]string{
+				appleEntryDataFork: "bytes",
+				appleEntryRealName: "notes",
+			}),
+			wantType: "application/octet-stream",
+			wantName: "notes",
+			wantBody: "bytes",
+			wantCT:   "application/octet-stream; name=notes",
+		},
+		{
+			name:     "truncated",
+			ctype:    "application/applesingle",
+			file:     []byte("AppleSingle"),
+			wantType: "application/applesingle",
+			wantBody: "AppleSingle",
+			wantCT:   "application/applesingle",
+		},
+	}
+	for _, tt := range tests {
+		raw := "Content-Type: multipart/mixed; boundary=B\r\n\r\n--B\r\n" +
+			"Content-Type: " + tt.ctype + "\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+			base64.StdEncoding.EncodeToString(tt.file) + "\r\n--B--\r\n"
+		root, err := ParseMIME(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Errorf("%v: ParseMIME() error: %v", tt.name, err)
+			continue
+		}
+		p := root.FirstChild()
+		if p.ContentType() != tt.wantType || p.FileName() != tt.wantName ||
+			string(p.Content()) != tt.wantBody {
+			t.Errorf("%v: got %v %q %q, want %v %q %q", tt.name, p.ContentType(), p.FileName(),
+				p.Content(), tt.wantType, tt.wantName, tt.wantBody)
+		}
+		if got := p.Header().Get("Content-Type"); got != tt.wantCT {
+			t.Errorf("%v: Content-Type header = %q, want %q", tt.name, got, tt.wantCT)
+		}
+	}
+}
+
+func TestParseAppleDouble(t *testing.T) {
+	resource := buildAppleFile(appleDoubleMagic, map[uint32]string{
+		appleEntryRealName: "budget.pdf",
+	})
+	raw := "Content-Type: multipart/mixed; boundary=A\r\n\r\n--A\r\n" +
+		"Content-Type: multipart/appledouble; boundary=B\r\n\r\n--B\r\n" +
+		"Content-Type: application/applefile\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		base64.StdEncoding.EncodeToString(resource) + "\r\n--B\r\n" +
+		"Content-Type: application/octet-stream\r\n\r\n%PDF\r\n--B--\r\n--A--\r\n"
+	root, err := ParseMIME(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ParseMIME() error: %v", err)
+	}
+	double := root.FirstChild()
+	rsrc := double.FirstChild()
+	data := rsrc.NextSibling()
+	if !IsAppleResourceFork(rsrc) || IsAppleResourceFork(data) {
+		t.Errorf("IsAppleResourceFork() = %v, %v, want true, false", IsAppleResourceFork(rsrc),
+			IsAppleResourceFork(data))
+	}
+	if data.ContentType() != "application/pdf" || data.FileName() != "budget.pdf" ||
+		data.Disposition() != "attachment" || double.Disposition() != "" {
+		t.Errorf("data fork is %v %q %q, wrapper %q, want application/pdf \"budget.pdf\" "+
+			"attachment, wrapper \"\"", data.ContentType(), data.FileName(), data.Disposition(),
+			double.Disposition())
+	}
+}
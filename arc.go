@@ -0,0 +1,281 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// arcMaxInstance is the highest ARC instance number RFC 8617 allows
+const arcMaxInstance = 50
+
+// ARC chain validation results, as recorded in the cv= tag of an ARC-Seal.
+const (
+	ARCNone = "none" // The message has no ARC sets
+	ARCPass = "pass" // Every seal, and the latest message signature, verified
+	ARCFail = "fail" // The chain is malformed or a signature did not verify
+)
+
+// ARCSet is one instance of the ARC header fields, added by each intermediary that handled
+// the message.
+type ARCSet struct {
+	Instance              int    // The i= tag, 1 for the first intermediary
+	Seal                  string // Value of ARC-Seal
+	MessageSignature      string // Value of ARC-Message-Signature
+	AuthenticationResults string // Value of ARC-Authentication-Results
+
+	seal, signature, results rawField
+	sealTags, signatureTags  map[string]string
+}
+
+// ARCChain holds the ARC sets of a message, ordered by instance, along with the header and
+// body needed to compute their canonicalized inputs.
+type ARCChain struct {
+	Sets   []ARCSet
+	header *RawHeader
+	body   []byte
+}
+
+// ARCKeyLookup fetches the TXT records published for a domain, net.LookupTXT will do.
+type ARCKeyLookup func(domain string) ([]string, error)
+
+// ReadARCChain reads a message from r and extracts its ARC chain.  A message without ARC
+// fields gives an empty chain; malformed ARC fields are an error, which validation would
+// report as a failure.
+func ReadARCChain(r io.Reader) (*ARCChain, error) {
+	br := bufio.NewReader(r)
+	h, err := ReadRawHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := h.ARCSets()
+	if err != nil {
+		return nil, err
+	}
+	return &ARCChain{Sets: sets, header: h, body: body}, nil
+}
+
+// ARCSets groups the ARC fields of the header by instance, in ascending order.  It fails if
+// an instance is missing one of its three fields or has duplicates, or if the instances are
+// not numbered 1 to N.
+func (h *RawHeader) ARCSets() ([]ARCSet, error) {
+	byInstance := make(map[int]*ARCSet)
+	for _, f := range h.fields {
+		switch f.key {
+		case "Arc-Seal", "Arc-Message-Signature", "Arc-Authentication-Results":
+		default:
+			continue
+		}
+		value := f.value()
+		instance, err := arcInstance(f.key, value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", f.key, err)
+		}
+		set := byInstance[instance]
+		if set == nil {
+			set = &ARCSet{Instance: instance}
+			byInstance[instance] = set
+		}
+		var slot *rawField
+		switch f.key {
+		case "Arc-Seal":
+			slot, set.Seal = &set.seal, value
+		case "Arc-Message-Signature":
+			slot, set.MessageSignature = &set.signature, value
+		default:
+			slot, set.AuthenticationResults = &set.results, value
+		}
+		if slot.raw != nil {
+			return nil, fmt.Errorf("Duplicate %v for ARC instance %v", f.key, instance)
+		}
+		*slot = f
+	}
+
+	sets := make([]ARCSet, len(byInstance))
+	for i := range sets {
+		set := byInstance[i+1]
+		if set == nil {
+			return nil, fmt.Errorf("ARC instance %v is missing", i+1)
+		}
+		if set.seal.raw == nil || set.signature.raw == nil || set.results.raw == nil {
+			return nil, fmt.Errorf("ARC instance %v is incomplete", i+1)
+		}
+		var err error
+		if set.sealTags, err = parseTagList(set.Seal); err != nil {
+			return nil, fmt.Errorf("ARC-Seal %v: %v", i+1, err)
+		}
+		if set.signatureTags, err = parseTagList(set.MessageSignature); err != nil {
+			return nil, fmt.Errorf("ARC-Message-Signature %v: %v", i+1, err)
+		}
+		sets[i] = *set
+	}
+	return sets, nil
+}
+
+// arcInstance returns the i= tag of an ARC field value.  ARC-Authentication-Results is not a
+// tag list, but starts with the same tag.
+func arcInstance(key, value string) (int, error) {
+	var tag string
+	if key == "Arc-Authentication-Results" {
+		tag = strings.SplitN(value, ";", 2)[0]
+		if i := strings.IndexByte(tag, '='); i >= 0 && strings.TrimSpace(tag[:i]) == "i" {
+			tag = tag[i+1:]
+		} else {
+			tag = ""
+		}
+	} else if tags, err := parseTagList(value); err == nil {
+		tag = tags["i"]
+	} else {
+		return 0, err
+	}
+	if tag = strings.TrimSpace(tag); tag == "" {
+		return 0, fmt.Errorf("Missing instance tag")
+	}
+	n, err := strconv.Atoi(tag)
+	if err != nil || n < 1 || n > arcMaxInstance {
+		return 0, fmt.Errorf("Invalid instance %q", tag)
+	}
+	return n, nil
+}
+
+// set returns the ARC set with the given instance number
+func (c *ARCChain) set(instance int) (*ARCSet, error) {
+	if instance < 1 || instance > len(c.Sets) {
+		return nil, fmt.Errorf("No ARC instance %v", instance)
+	}
+	return &c.Sets[instance-1], nil
+}
+
+// SealInput returns the canonicalized data signed by the ARC-Seal of the given instance: the
+// sets up to and including it, with that seal's signature removed.
+func (c *ARCChain) SealInput(instance int) ([]byte, error) {
+	if _, err := c.set(instance); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	for i := 0; i < instance; i++ {
+		s := &c.Sets[i]
+		out.Write(canonHeader(s.results.raw, true))
+		out.Write(canonHeader(s.signature.raw, true))
+		seal := s.seal.raw
+		if i == instance-1 {
+			seal = stripSignature(seal)
+		}
+		out.Write(canonHeader(seal, true))
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\r\n")), nil
+}
+
+// SignatureInput returns the canonicalized header data signed by the ARC-Message-Signature
+// of the given instance: the fields it lists, then the signature itself with its signature
+// removed.
+func (c *ARCChain) SignatureInput(instance int) ([]byte, error) {
+	s, err := c.set(instance)
+	if err != nil {
+		return nil, err
+	}
+	canon, _ := ParseCanonicalization(s.signatureTags["c"])
+	data := selectHeaders(c.header.fields, s.signatureTags["h"], canon == CanonRelaxed)
+	sig := CanonicalizeHeader(stripSignature(s.signature.raw), canon)
+	return append(data, bytes.TrimSuffix(sig, []byte("\r\n"))...), nil
+}
+
+// BodyHash computes the body hash the ARC-Message-Signature of the given instance should
+// carry in its bh= tag.
+func (c *ARCChain) BodyHash(instance int) ([]byte, error) {
+	s, err := c.set(instance)
+	if err != nil {
+		return nil, err
+	}
+	_, canon := ParseCanonicalization(s.signatureTags["c"])
+	body := CanonicalizeBody(c.body, canon)
+	if l := s.signatureTags["l"]; l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid body length %q", l)
+		}
+		if n < len(body) {
+			body = body[:n]
+		}
+	}
+	_, newHash, err := signatureHash(s.signatureTags["a"])
+	if err != nil {
+		return nil, err
+	}
+	h := newHash()
+	h.Write(body)
+	return h.Sum(nil), nil
+}
+
+// Validate verifies the chain as RFC 8617 describes, fetching keys with lookup.  It returns
+// ARCNone, ARCPass or ARCFail, and a description of the first problem found for ARCFail.  An
+// error is returned only if a key could not be fetched, a temporary failure.
+func (c *ARCChain) Validate(lookup ARCKeyLookup) (result, reason string, err error) {
+	n := len(c.Sets)
+	if n == 0 {
+		return ARCNone, "", nil
+	}
+	if cv := strings.ToLower(c.Sets[n-1].sealTags["cv"]); cv == ARCFail {
+		return ARCFail, fmt.Sprintf("ARC-Seal %v reports a failed chain", n), nil
+	}
+	for i, s := range c.Sets {
+		want := ARCPass
+		if i == 0 {
+			want = ARCNone
+		}
+		if cv := strings.ToLower(s.sealTags["cv"]); cv != want {
+			return ARCFail, fmt.Sprintf("ARC-Seal %v has cv=%v, expected %v", i+1, cv, want), nil
+		}
+	}
+
+	// The most recent message signature must verify
+	s := &c.Sets[n-1]
+	bh, err := c.BodyHash(n)
+	if err != nil {
+		return ARCFail, fmt.Sprintf("ARC-Message-Signature %v: %v", n, err), nil
+	}
+	if base64.StdEncoding.EncodeToString(bh) != s.signatureTags["bh"] {
+		return ARCFail, fmt.Sprintf("ARC-Message-Signature %v body hash mismatch", n), nil
+	}
+	data, _ := c.SignatureInput(n)
+	if reason, err := c.verify(lookup, s.signatureTags, data); reason != "" || err != nil {
+		return ARCFail, fmt.Sprintf("ARC-Message-Signature %v: %v", n, reason), err
+	}
+
+	// Every seal must verify, most recent first
+	for i := n; i >= 1; i-- {
+		data, _ := c.SealInput(i)
+		if reason, err := c.verify(lookup, c.Sets[i-1].sealTags, data); reason != "" || err != nil {
+			return ARCFail, fmt.Sprintf("ARC-Seal %v: %v", i, reason), err
+		}
+	}
+	return ARCPass, "", nil
+}
+
+// verify checks the signature in tags over data, returning a reason if it does not verify
+// or an error if its key could not be fetched
+func (c *ARCChain) verify(lookup ARCKeyLookup, tags map[string]string, data []byte) (string,
+	error) {
+	if tags["d"] == "" || tags["s"] == "" || tags["b"] == "" {
+		return "missing d=, s= or b= tag", nil
+	}
+	records, err := lookup(tags["s"] + "._domainkey." + tags["d"])
+	if err != nil {
+		return "key lookup failed", err
+	}
+	if len(records) == 0 {
+		return "no key published", nil
+	}
+	if err := verifySignature(tags["a"], records[0], data, tags["b"]); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,78 @@
+package enmime
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ArchiveEntry describes one file in an archive attachment.
+type ArchiveEntry struct {
+	Name           string // Path within the archive
+	Size           uint64 // Uncompressed size, as claimed by the archive
+	CompressedSize uint64
+	Modified       time.Time
+	Dir            bool // Entry is a directory
+	Encrypted      bool // Entry is password protected, so its content cannot be scanned
+}
+
+// ArchiveListing is the table of contents of an archive attachment.
+type ArchiveListing struct {
+	Entries   []ArchiveEntry
+	Encrypted bool   // At least one entry is encrypted
+	Size      uint64 // Total uncompressed size, large ratios to the attachment size are bombs
+	Comment   string
+}
+
+// IsZip returns true if p holds a zip archive, judged by its content rather than its
+// declared type, which senders of malware often disguise.
+func IsZip(p MIMEPart) bool {
+	c := p.Content()
+	return bytes.HasPrefix(c, []byte("PK\x03\x04")) || bytes.HasPrefix(c, []byte("PK\x05\x06"))
+}
+
+// ListZip lists the files in a zip archive attachment from its central directory, without
+// extracting them.  Only zip is supported; the standard library has no Zstandard or RAR
+// reader.
+func ListZip(p MIMEPart) (*ArchiveListing, error) {
+	content := p.Content()
+	r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read zip archive %v: %v", p.FileName(), err)
+	}
+	l := &ArchiveListing{Comment: r.Comment}
+	for _, f := range r.File {
+		e := ArchiveEntry{
+			Name:           f.Name,
+			Size:           f.UncompressedSize64,
+			CompressedSize: f.CompressedSize64,
+			Modified:       f.Modified,
+			Dir:            strings.HasSuffix(f.Name, "/"),
+			// Bit 0 of the general purpose flags marks encryption
+			Encrypted: f.Flags&0x1 != 0,
+		}
+		l.Encrypted = l.Encrypted || e.Encrypted
+		l.Size += e.Size
+		l.Entries = append(l.Entries, e)
+	}
+	return l, nil
+}
+
+// Extensions returns the distinct lower case file extensions in the listing, such as ".exe",
+// for matching against blocked types.
+func (l *ArchiveListing) Extensions() []string {
+	seen := make(map[string]bool)
+	var exts []string
+	for _, e := range l.Entries {
+		ext := strings.ToLower(path.Ext(e.Name))
+		if e.Dir || ext == "" || seen[ext] {
+			continue
+		}
+		seen[ext] = true
+		exts = append(exts, ext)
+	}
+	return exts
+}
@@ -0,0 +1,212 @@
+package enmime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AuthResults is a parsed RFC 8601 Authentication-Results header, recording the verdicts of
+// the SPF, DKIM, DMARC, ARC and other checks made by an upstream server.
+type AuthResults struct {
+	ServID  string       // Identifier of the server that made the checks
+	Version string       // Version of the header syntax, "" if omitted
+	Results []AuthResult // Empty if no checks were made
+}
+
+// AuthResult is the outcome of one authentication method.
+type AuthResult struct {
+	Method  string // Method name in lower case, such as "dkim"
+	Version string // Method version, "" if omitted
+	Result  string // Result in lower case, such as "pass" or "fail"
+	Reason  string // Explanation, if given
+	// Properties of the message that were checked, keyed by "ptype.property" in lower case,
+	// such as "smtp.mailfrom" or "header.d"
+	Properties map[string]string
+}
+
+// Result returns the first result for method, or nil if there is none.
+func (a *AuthResults) Result(method string) *AuthResult {
+	method = strings.ToLower(method)
+	for i := range a.Results {
+		if a.Results[i].Method == method {
+			return &a.Results[i]
+		}
+	}
+	return nil
+}
+
+// AuthenticationResults parses every Authentication-Results header of the message, most
+// recently added first.  Headers that cannot be parsed are skipped.  Only headers added by
+// servers whose ServID the caller trusts should be believed, as senders can add their own.
+func (m *MIMEBody) AuthenticationResults() []*AuthResults {
+	var results []*AuthResults
+	for _, v := range m.header["Authentication-Results"] {
+		if a, err := ParseAuthenticationResults(v); err == nil {
+			results = append(results, a)
+		}
+	}
+	return results
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results header.
+func ParseAuthenticationResults(value string) (*AuthResults, error) {
+	toks, err := lexAuthResults(value)
+	if err != nil {
+		return nil, err
+	}
+	p := &authResultsParser{toks: toks}
+
+	a := &AuthResults{}
+	if a.ServID = p.word(); a.ServID == "" {
+		return nil, fmt.Errorf("Authentication-Results missing authserv-id: %q", value)
+	}
+	if p.peek() != ";" && p.peek() != "" {
+		a.Version = p.word()
+	}
+	for p.peek() != "" {
+		if p.next() != ";" {
+			return nil, fmt.Errorf("Authentication-Results expected ';': %q", value)
+		}
+		if p.peek() == "" {
+			// Tolerate a trailing semicolon
+			break
+		}
+		method := strings.ToLower(p.word())
+		if method == "none" && len(a.Results) == 0 && p.peek() != "=" && p.peek() != "/" {
+			continue
+		}
+		r := AuthResult{Method: method, Properties: make(map[string]string)}
+		if p.peek() == "/" {
+			p.next()
+			r.Version = p.word()
+		}
+		if method == "" || p.next() != "=" {
+			return nil, fmt.Errorf("Authentication-Results invalid method %q: %q", method, value)
+		}
+		if r.Result = strings.ToLower(p.word()); r.Result == "" {
+			return nil, fmt.Errorf("Authentication-Results missing %v result: %q", method, value)
+		}
+		for p.peek() != ";" && p.peek() != "" {
+			key := strings.ToLower(p.word())
+			if key == "" || p.next() != "=" {
+				return nil, fmt.Errorf("Authentication-Results invalid %v property: %q", method,
+					value)
+			}
+			if key == "reason" {
+				r.Reason = p.word()
+			} else {
+				r.Properties[key] = p.word()
+			}
+		}
+		a.Results = append(a.Results, r)
+	}
+	return a, nil
+}
+
+// authToken is a word or one of the separators ";", "=" and "/"
+type authToken struct {
+	text  string
+	punct bool
+}
+
+// authResultsParser consumes the tokens of an Authentication-Results value
+type authResultsParser struct {
+	toks []authToken
+	pos  int
+}
+
+// peek returns the next separator, "" at the end, or "w" if the next token is a word
+func (p *authResultsParser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	if !p.toks[p.pos].punct {
+		return "w"
+	}
+	return p.toks[p.pos].text
+}
+
+// next consumes the next token, returning its text
+func (p *authResultsParser) next() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	p.pos++
+	return p.toks[p.pos-1].text
+}
+
+// word consumes the next token if it is a word, returning "" otherwise
+func (p *authResultsParser) word() string {
+	if p.peek() != "w" {
+		return ""
+	}
+	return p.next()
+}
+
+// lexAuthResults splits an Authentication-Results value into tokens, dropping white space
+// and comments and unquoting quoted strings
+func lexAuthResults(s string) ([]authToken, error) {
+	var toks []authToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			// Comments nest, and may contain quoted pairs
+			depth := 0
+			for ; i < len(s); i++ {
+				if s[i] == '\\' {
+					i++
+				} else if s[i] == '(' {
+					depth++
+				} else if s[i] == ')' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+			if depth != 0 {
+				return nil, fmt.Errorf("Authentication-Results unterminated comment: %q", s)
+			}
+			i++
+		case c == '=' && len(toks) > 0 && strings.Contains(toks[len(toks)-1].text, "."):
+			// Property values are often unquoted base64 or addresses, take them whole
+			toks = append(toks, authToken{text: "=", punct: true})
+			for i++; i < len(s) && (s[i] == ' ' || s[i] == '\t'); i++ {
+			}
+			j := i
+			for j < len(s) && s[j] != '"' && !strings.ContainsRune(" \t\r\n();", rune(s[j])) {
+				j++
+			}
+			if j > i {
+				toks = append(toks, authToken{text: s[i:j]})
+			}
+			i = j
+		case c == ';' || c == '=' || c == '/':
+			toks = append(toks, authToken{text: string(c), punct: true})
+			i++
+		case c == '"':
+			var b strings.Builder
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("Authentication-Results unterminated quoted string: %q", s)
+			}
+			toks = append(toks, authToken{text: b.String()})
+			i++
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\r\n();=/\"", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, authToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
@@ -0,0 +1,312 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+)
+
+// cacheVersion identifies the layout of MarshalBinary output, changed whenever it changes
+const cacheVersion = 1
+
+// cachedBody is the layout MarshalBinary writes, with the part tree flattened in depth first
+// order
+type cachedBody struct {
+	Version     int
+	Text        string
+	Html        string
+	Header      map[string][]string
+	RawBody     []byte
+	Warnings    []Warning
+	Scans       []ScanResult
+	Parts       []cachedPart
+	Root        int // Index of the root in Parts, -1 for a text only message
+	Attachments []int
+	Inlines     []int
+	TextOffsets *cachedOffsets
+}
+
+// cachedPart is one MIMEPart, linked to its parent by index
+type cachedPart struct {
+	Parent       int // Index of the parent in Parts, -1 for the root and detached parts
+	Header       map[string][]string
+	ContentType  string
+	Disposition  string
+	FileName     string
+	PartID       string
+	Content      []byte
+	SharedWith   int // Index of an earlier part sharing the content buffer, or -1
+	RawContent   []byte
+	Err          string
+	Scans        []ScanResult
+	RawSize      int
+	RawLines     int
+	Charset      string
+	Decompressed bool
+	Offsets      *cachedOffsets
+}
+
+// cachedOffsets is an OffsetMap
+type cachedOffsets struct {
+	Runs       []cachedRun
+	DecodedLen int
+	RawLen     int
+}
+
+// cachedRun is an offsetRun
+type cachedRun struct {
+	Decoded    int
+	Start, End int
+	Linear     bool
+}
+
+// MarshalBinary encodes the parsed message, including its part tree and decoded content, for
+// a later processing stage to restore with UnmarshalBinary instead of parsing the raw message
+// again.  The encoding is only meant to be read back by the same version of this package.
+// Only parts created by this package can be encoded, others make it return an error.
+func (m *MIMEBody) MarshalBinary() ([]byte, error) {
+	c := cachedBody{
+		Version:  cacheVersion,
+		Text:     m.Text,
+		Html:     m.Html,
+		Header:   m.header,
+		RawBody:  m.rawBody,
+		Warnings: m.Warnings,
+		Scans:    m.Scans,
+		Root:     -1,
+	}
+	index := make(map[MIMEPart]int)
+	shared := make(map[*byte]int)
+	var add func(p MIMEPart, parent int) (int, error)
+	add = func(p MIMEPart, parent int) (int, error) {
+		mp, ok := p.(*memMIMEPart)
+		if !ok {
+			return 0, fmt.Errorf("Unable to encode part of type %T", p)
+		}
+		i := len(c.Parts)
+		index[p] = i
+		cp := cachedPart{
+			Parent:       parent,
+			Header:       mp.header,
+			ContentType:  mp.contentType,
+			Disposition:  mp.disposition,
+			FileName:     mp.fileName,
+			PartID:       mp.partID,
+			SharedWith:   -1,
+			RawContent:   mp.rawContent,
+			Scans:        mp.scans,
+			RawSize:      mp.rawSize,
+			RawLines:     mp.rawLines,
+			Charset:      mp.charset,
+			Decompressed: mp.decompressed,
+			Offsets:      encodeOffsets(mp.offsets),
+		}
+		if mp.err != nil {
+			cp.Err = mp.err.Error()
+		}
+		if mp.storage != nil {
+			// The restored part holds its content in memory
+			cp.Content = mp.Content()
+		} else if j, ok := shared[firstByte(mp.content)]; ok && mp.shared {
+			cp.SharedWith = j
+		} else {
+			cp.Content = mp.content
+			if mp.shared {
+				shared[firstByte(mp.content)] = i
+			}
+		}
+		c.Parts = append(c.Parts, cp)
+		for child := mp.firstChild; child != nil; child = child.NextSibling() {
+			if _, err := add(child, i); err != nil {
+				return 0, err
+			}
+		}
+		return i, nil
+	}
+	if m.Root != nil {
+		var err error
+		if c.Root, err = add(m.Root, -1); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parts extracted from the text body are not in the tree
+	indexes := func(parts []MIMEPart) ([]int, error) {
+		var list []int
+		for _, p := range parts {
+			i, ok := index[p]
+			if !ok {
+				var err error
+				if i, err = add(p, -1); err != nil {
+					return nil, err
+				}
+			}
+			list = append(list, i)
+		}
+		return list, nil
+	}
+	var err error
+	if c.Attachments, err = indexes(m.Attachments); err != nil {
+		return nil, err
+	}
+	if c.Inlines, err = indexes(m.Inlines); err != nil {
+		return nil, err
+	}
+	c.TextOffsets = encodeOffsets(m.textOffsets)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&c); err != nil {
+		return nil, fmt.Errorf("Unable to encode message: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores a message encoded by MarshalBinary.
+func (m *MIMEBody) UnmarshalBinary(data []byte) error {
+	var c cachedBody
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return fmt.Errorf("Unable to decode message: %v", err)
+	}
+	if c.Version != cacheVersion {
+		return fmt.Errorf("Unsupported encoded message version %v", c.Version)
+	}
+
+	parts := make([]*memMIMEPart, len(c.Parts))
+	for i, cp := range c.Parts {
+		p := &memMIMEPart{
+			header:       textproto.MIMEHeader(cp.Header),
+			contentType:  cp.ContentType,
+			disposition:  cp.Disposition,
+			fileName:     cp.FileName,
+			partID:       cp.PartID,
+			content:      cp.Content,
+			rawContent:   cp.RawContent,
+			scans:        cp.Scans,
+			rawSize:      cp.RawSize,
+			rawLines:     cp.RawLines,
+			charset:      cp.Charset,
+			decompressed: cp.Decompressed,
+		}
+		if cp.Err != "" {
+			p.err = errors.New(cp.Err)
+		}
+		var err error
+		if p.offsets, err = decodeOffsets(cp.Offsets); err != nil {
+			return fmt.Errorf("Invalid offset map in part %v: %v", i, err)
+		}
+		if cp.SharedWith >= 0 {
+			if cp.SharedWith >= i {
+				return fmt.Errorf("Invalid shared content reference in part %v", i)
+			}
+			p.content = parts[cp.SharedWith].content
+			p.shared = true
+			parts[cp.SharedWith].shared = true
+		}
+		if cp.Parent >= 0 {
+			if cp.Parent >= i {
+				return fmt.Errorf("Invalid parent reference in part %v", i)
+			}
+			appendChild(parts[cp.Parent], p)
+		}
+		parts[i] = p
+	}
+	lookup := func(list []int) ([]MIMEPart, error) {
+		if list == nil {
+			return nil, nil
+		}
+		result := make([]MIMEPart, len(list))
+		for i, j := range list {
+			if j < 0 || j >= len(parts) {
+				return nil, fmt.Errorf("Invalid part reference %v", j)
+			}
+			result[i] = parts[j]
+		}
+		return result, nil
+	}
+
+	body := MIMEBody{
+		Text:     c.Text,
+		Html:     c.Html,
+		Warnings: c.Warnings,
+		Scans:    c.Scans,
+		header:   mail.Header(c.Header),
+		rawBody:  c.RawBody,
+	}
+	if c.Root >= 0 {
+		if c.Root >= len(parts) {
+			return fmt.Errorf("Invalid root reference %v", c.Root)
+		}
+		body.Root = parts[c.Root]
+	}
+	var err error
+	if body.textOffsets, err = decodeOffsets(c.TextOffsets); err != nil {
+		return fmt.Errorf("Invalid text offset map: %v", err)
+	}
+	if body.Attachments, err = lookup(c.Attachments); err != nil {
+		return err
+	}
+	if body.Inlines, err = lookup(c.Inlines); err != nil {
+		return err
+	}
+	*m = body
+	return nil
+}
+
+// firstByte identifies a content buffer by the address of its first byte
+func firstByte(b []byte) *byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return &b[0]
+}
+
+// encodeOffsets returns the cached form of m, or nil if m is nil
+func encodeOffsets(m *OffsetMap) *cachedOffsets {
+	if m == nil {
+		return nil
+	}
+	c := &cachedOffsets{Runs: make([]cachedRun, len(m.runs)), DecodedLen: m.decodedLen,
+		RawLen: m.rawLen}
+	for i, r := range m.runs {
+		c.Runs[i] = cachedRun{Decoded: r.decoded, Start: r.start, End: r.end, Linear: r.linear}
+	}
+	return c
+}
+
+// decodeOffsets restores the OffsetMap encoded as c, checking that its runs cover the decoded
+// content in order
+func decodeOffsets(c *cachedOffsets) (*OffsetMap, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.DecodedLen < 0 || c.RawLen < 0 {
+		return nil, fmt.Errorf("Invalid offset map length")
+	}
+	if c.DecodedLen > 0 && (len(c.Runs) == 0 || c.Runs[0].Decoded != 0) {
+		return nil, fmt.Errorf("Runs do not start at offset 0")
+	}
+	m := &OffsetMap{runs: make([]offsetRun, len(c.Runs)), decodedLen: c.DecodedLen,
+		rawLen: c.RawLen}
+	for i, r := range c.Runs {
+		if i > 0 && r.Decoded <= c.Runs[i-1].Decoded || r.Decoded >= c.DecodedLen {
+			return nil, fmt.Errorf("Run %v is out of order", i)
+		}
+		// A linear run moves through the body, its last byte must still be within it
+		last := r.End
+		if r.Linear {
+			next := c.DecodedLen
+			if i+1 < len(c.Runs) {
+				next = c.Runs[i+1].Decoded
+			}
+			last += next - 1 - r.Decoded
+		}
+		if r.Start < 0 || r.Start > r.End || r.End > c.RawLen || last > c.RawLen {
+			return nil, fmt.Errorf("Run %v is outside the body", i)
+		}
+		m.runs[i] = offsetRun{decoded: r.Decoded, start: r.Start, end: r.End, linear: r.Linear}
+	}
+	return m, nil
+}
@@ -0,0 +1,344 @@
+package enmime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net/textproto"
+	"strings"
+)
+
+// DKIM (RFC 6376) and ARC (RFC 8617) sign canonicalized forms of the header and body, which
+// survive the changes mail systems commonly make in transit.
+
+// Canonicalization is a DKIM canonicalization algorithm.
+type Canonicalization int
+
+const (
+	// CanonSimple tolerates almost no change: only empty lines at the end of the body
+	CanonSimple Canonicalization = iota
+	// CanonRelaxed also tolerates changes to white space, folding and header field name case
+	CanonRelaxed
+)
+
+// String returns the name used in the c= tag
+func (c Canonicalization) String() string {
+	if c == CanonRelaxed {
+		return "relaxed"
+	}
+	return "simple"
+}
+
+// ParseCanonicalization parses a c= tag such as "relaxed/simple" into its header and body
+// algorithms.  Either defaults to simple when omitted or unknown.
+func ParseCanonicalization(tag string) (header, body Canonicalization) {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(tag)), "/", 2)
+	if parts[0] == "relaxed" {
+		header = CanonRelaxed
+	}
+	if len(parts) == 2 && parts[1] == "relaxed" {
+		body = CanonRelaxed
+	}
+	return header, body
+}
+
+// CanonicalizeBody returns the canonicalized form of a message body, the input to a DKIM
+// body hash.  Apply any l= length limit to the result.
+func CanonicalizeBody(body []byte, c Canonicalization) []byte {
+	return canonBody(body, c == CanonRelaxed)
+}
+
+// CanonicalizeHeader returns the canonicalized form of one raw header field, including its
+// continuation lines, ending in CRLF.
+func CanonicalizeHeader(field []byte, c Canonicalization) []byte {
+	return canonHeader(field, c == CanonRelaxed)
+}
+
+// CanonicalHeaders returns the canonicalized fields a DKIM signature with the given h= list
+// of names covers, in signing order.  A name listed more than once selects the fields of
+// that name from the bottom of the header up; names without a field are skipped.  The
+// DKIM-Signature field itself is not included.
+func (h *RawHeader) CanonicalHeaders(names []string, c Canonicalization) []byte {
+	return selectHeaders(h.fields, strings.Join(names, ":"), c == CanonRelaxed)
+}
+
+// CanonicalHeaders is like RawHeader.CanonicalHeaders, using the parsed header of the
+// message.  Parsing unfolds fields and changes the case of their names, so only relaxed
+// canonicalization can be reproduced from it.
+func (m *MIMEBody) CanonicalHeaders(names []string) []byte {
+	var fields []rawField
+	for key, values := range m.header {
+		for _, v := range values {
+			fields = append(fields, rawField{key: key, raw: []byte(key + ": " + v + "\r\n")})
+		}
+	}
+	return selectHeaders(fields, strings.Join(names, ":"), true)
+}
+
+// CanonicalBody returns the canonicalized body of the message.  The body must have been
+// kept by parsing with ParseOptions.KeepRawBody.
+func (m *MIMEBody) CanonicalBody(c Canonicalization) ([]byte, error) {
+	if m.rawBody == nil {
+		return nil, fmt.Errorf("Raw body was not kept, parse with KeepRawBody")
+	}
+	return canonBody(m.rawBody, c == CanonRelaxed), nil
+}
+
+// canonHeader canonicalizes a raw header field, including its line ending, using the simple
+// or relaxed algorithm
+func canonHeader(raw []byte, relaxed bool) []byte {
+	if !relaxed {
+		return crlfLines(raw)
+	}
+	s := string(raw)
+	name, value := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, value = s[:i], s[i+1:]
+	}
+	value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+	return []byte(strings.ToLower(strings.TrimRight(name, " \t")) + ":" +
+		compressWSP(value) + "\r\n")
+}
+
+// canonBody canonicalizes a message body using the simple or relaxed algorithm
+func canonBody(body []byte, relaxed bool) []byte {
+	lines := bytes.SplitAfter(crlfLines(body), []byte("\r\n"))
+	var out bytes.Buffer
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if relaxed {
+			text := bytes.TrimSuffix(line, []byte("\r\n"))
+			out.WriteString(strings.TrimRight(compressWSPKeep(string(text)), " \t"))
+			out.WriteString("\r\n")
+		} else {
+			out.Write(line)
+			if !bytes.HasSuffix(line, []byte("\r\n")) {
+				out.WriteString("\r\n")
+			}
+		}
+	}
+
+	// Ignore empty lines at the end of the body
+	b := out.Bytes()
+	for bytes.HasSuffix(b, []byte("\r\n\r\n")) {
+		b = b[:len(b)-2]
+	}
+	if len(b) == 2 && relaxed {
+		b = b[:0]
+	}
+	if len(b) == 0 && !relaxed {
+		b = []byte("\r\n")
+	}
+	return b
+}
+
+// crlfLines converts lone LF line endings to CRLF
+func crlfLines(b []byte) []byte {
+	if !bytes.Contains(b, []byte("\n")) {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len(b)/32)
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// compressWSP reduces each run of spaces and tabs to one space, and trims them from both ends
+func compressWSP(s string) string {
+	return strings.TrimSpace(compressWSPKeep(s))
+}
+
+// compressWSPKeep reduces each run of spaces and tabs to one space
+func compressWSPKeep(s string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(s[i])
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// parseTagList parses a DKIM style "tag=value; tag=value" list.  Folding white space is
+// removed from the base64 b= and bh= values.
+func parseTagList(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(s, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		i := strings.IndexByte(spec, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid tag %q", strings.TrimSpace(spec))
+		}
+		name := strings.TrimSpace(spec[:i])
+		value := strings.TrimSpace(spec[i+1:])
+		if name == "b" || name == "bh" || name == "p" {
+			value = strings.Map(func(r rune) rune {
+				if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+					return -1
+				}
+				return r
+			}, value)
+		}
+		if _, ok := tags[name]; ok {
+			return nil, fmt.Errorf("Duplicate tag %q", name)
+		}
+		tags[name] = value
+	}
+	return tags, nil
+}
+
+// stripSignature returns the raw signature field with the value of its b= tag removed, as
+// it is when the signature is computed
+func stripSignature(raw []byte) []byte {
+	s := string(raw)
+	start := strings.IndexByte(s, ':') + 1
+	for start < len(s) {
+		end := strings.IndexByte(s[start:], ';')
+		if end < 0 {
+			end = len(s)
+		} else {
+			end += start
+		}
+		spec := s[start:end]
+		if i := strings.IndexByte(spec, '='); i >= 0 && strings.TrimSpace(spec[:i]) == "b" {
+			stripped := s[:start+i+1] + s[end:]
+			if end == len(s) && strings.HasSuffix(s, "\n") {
+				// The line ending is part of the last tag's value, keep it
+				stripped = s[:start+i+1] + lineEnding(s)
+			}
+			return []byte(stripped)
+		}
+		start = end + 1
+	}
+	return raw
+}
+
+// lineEnding returns the line ending of s
+func lineEnding(s string) string {
+	if strings.HasSuffix(s, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// selectHeaders returns the canonicalized fields named in signedNames, picking instances of
+// a repeated field from the bottom of the header up as DKIM requires.  Names with no unused
+// instance left contribute nothing.
+func selectHeaders(fields []rawField, signedNames string, relaxed bool) []byte {
+	used := make(map[int]bool)
+	var out bytes.Buffer
+	for _, name := range strings.Split(signedNames, ":") {
+		key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+		for i := len(fields) - 1; i >= 0; i-- {
+			if fields[i].key == key && !used[i] {
+				used[i] = true
+				out.Write(canonHeader(fields[i].raw, relaxed))
+				break
+			}
+		}
+	}
+	return out.Bytes()
+}
+
+// signatureHash returns the hash function for a DKIM a= algorithm
+func signatureHash(algorithm string) (crypto.Hash, func() hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "rsa-sha256", "ed25519-sha256":
+		return crypto.SHA256, sha256.New, nil
+	case "rsa-sha1":
+		return crypto.SHA1, sha1.New, nil
+	}
+	return 0, nil, fmt.Errorf("Unsupported signature algorithm %q", algorithm)
+}
+
+// verifySignature checks sig, the base64 b= value, over data using the public key published
+// in a DKIM key record
+func verifySignature(algorithm, record string, data []byte, sig string) error {
+	keyTags, err := parseTagList(record)
+	if err != nil {
+		return fmt.Errorf("Invalid key record: %v", err)
+	}
+	if keyTags["p"] == "" {
+		return fmt.Errorf("Key has been revoked")
+	}
+	keyData, err := base64.StdEncoding.DecodeString(keyTags["p"])
+	if err != nil {
+		return fmt.Errorf("Invalid key record: %v", err)
+	}
+	sigData, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("Invalid signature encoding: %v", err)
+	}
+	hashID, newHash, err := signatureHash(algorithm)
+	if err != nil {
+		return err
+	}
+	h := newHash()
+	h.Write(data)
+	sum := h.Sum(nil)
+
+	keyType := strings.ToLower(keyTags["k"])
+	if keyType == "" {
+		keyType = "rsa"
+	}
+	if !strings.HasPrefix(strings.ToLower(algorithm), keyType+"-") {
+		return fmt.Errorf("Key type %v does not match algorithm %v", keyType, algorithm)
+	}
+	switch keyType {
+	case "rsa":
+		pub, err := parseRSAKey(keyData)
+		if err != nil {
+			return err
+		}
+		return rsa.VerifyPKCS1v15(pub, hashID, sum, sigData)
+	case "ed25519":
+		if len(keyData) != ed25519.PublicKeySize {
+			return fmt.Errorf("Invalid ed25519 key length %v", len(keyData))
+		}
+		if !ed25519.Verify(ed25519.PublicKey(keyData), sum, sigData) {
+			return fmt.Errorf("Signature does not verify")
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported key type %q", keyType)
+}
+
+// parseRSAKey parses an RSA public key in SubjectPublicKeyInfo or PKCS #1 form
+func parseRSAKey(der []byte) (*rsa.PublicKey, error) {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if pub, ok := key.(*rsa.PublicKey); ok {
+			return pub, nil
+		}
+		return nil, fmt.Errorf("Key is not an RSA key")
+	}
+	pub, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid RSA key: %v", err)
+	}
+	return pub, nil
+}
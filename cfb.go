@@ -0,0 +1,264 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Compound File Binary (MS-CFB) is the container format of Outlook .msg files, a small FAT
+// filesystem holding a tree of storages (directories) and streams (files).
+
+const (
+	cfbMaxRegSect = 0xFFFFFFFA // Highest regular sector number
+	cfbFreeSect   = 0xFFFFFFFF // Unallocated sector
+	cfbEndOfChain = 0xFFFFFFFE // Last sector in a chain
+	cfbNoStream   = 0xFFFFFFFF // Empty directory tree link
+
+	cfbTypeStorage = 1
+	cfbTypeStream  = 2
+	cfbTypeRoot    = 5
+
+	cfbHeaderSize   = 512
+	cfbDirEntrySize = 128
+)
+
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// cfbEntry is a directory entry, describing a storage or stream
+type cfbEntry struct {
+	name  string
+	typ   byte
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	size  uint64
+}
+
+// cfbFile is a compound file loaded into memory
+type cfbFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFat        []uint32
+	miniStream     []byte
+	entries        []cfbEntry
+}
+
+// isCFB returns true if data begins with the compound file signature
+func isCFB(data []byte) bool {
+	return bytes.HasPrefix(data, cfbSignature)
+}
+
+// openCFB parses the header, allocation tables and directory of a compound file
+func openCFB(data []byte) (*cfbFile, error) {
+	if len(data) < cfbHeaderSize || !isCFB(data) {
+		return nil, fmt.Errorf("Not a compound file")
+	}
+	le := binary.LittleEndian
+	sectorShift := le.Uint16(data[0x1E:])
+	miniShift := le.Uint16(data[0x20:])
+	if sectorShift != 9 && sectorShift != 12 || miniShift != 6 {
+		return nil, fmt.Errorf("Unsupported compound file sector size 2^%v", sectorShift)
+	}
+	f := &cfbFile{
+		data:           data,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniShift,
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+	}
+	numFatSectors := int(le.Uint32(data[0x2C:]))
+	firstDirSector := le.Uint32(data[0x30:])
+	firstMiniFatSector := le.Uint32(data[0x3C:])
+	firstDifatSector := le.Uint32(data[0x44:])
+
+	// Collect the FAT sector numbers from the header and the DIFAT chain
+	var fatSectors []uint32
+	for i := 0; i < 109; i++ {
+		fatSectors = append(fatSectors, le.Uint32(data[0x4C+4*i:]))
+	}
+	perSector := f.sectorSize / 4
+	seen := make(map[uint32]bool)
+	for s := firstDifatSector; s != cfbEndOfChain && s != cfbFreeSect; {
+		if seen[s] {
+			return nil, fmt.Errorf("Loop in compound file DIFAT chain")
+		}
+		seen[s] = true
+		sector, err := f.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector-1; i++ {
+			fatSectors = append(fatSectors, le.Uint32(sector[4*i:]))
+		}
+		s = le.Uint32(sector[4*(perSector-1):])
+	}
+	if numFatSectors > len(fatSectors) {
+		return nil, fmt.Errorf("Compound file declares %v FAT sectors, found %v", numFatSectors,
+			len(fatSectors))
+	}
+
+	// Load the FAT
+	for _, s := range fatSectors[:numFatSectors] {
+		sector, err := f.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector; i++ {
+			f.fat = append(f.fat, le.Uint32(sector[4*i:]))
+		}
+	}
+
+	// Load the directory
+	dir, err := f.chain(firstDirSector)
+	if err != nil {
+		return nil, err
+	}
+	for off := 0; off+cfbDirEntrySize <= len(dir); off += cfbDirEntrySize {
+		e := parseCFBEntry(dir[off : off+cfbDirEntrySize])
+		if f.sectorSize == 512 {
+			// Version 3 files may leave garbage in the high bits of the size
+			e.size &= 0xFFFFFFFF
+		}
+		f.entries = append(f.entries, e)
+	}
+	if len(f.entries) == 0 || f.entries[0].typ != cfbTypeRoot {
+		return nil, fmt.Errorf("Compound file is missing its root entry")
+	}
+
+	// Load the mini FAT and mini stream, which hold streams smaller than the cutoff
+	if firstMiniFatSector != cfbEndOfChain && firstMiniFatSector != cfbFreeSect {
+		miniFat, err := f.chain(firstMiniFatSector)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+4 <= len(miniFat); i += 4 {
+			f.miniFat = append(f.miniFat, le.Uint32(miniFat[i:]))
+		}
+	}
+	root := f.entries[0]
+	if root.start != cfbEndOfChain && root.start != cfbFreeSect {
+		if f.miniStream, err = f.chain(root.start); err != nil {
+			return nil, err
+		}
+		if uint64(len(f.miniStream)) > root.size {
+			f.miniStream = f.miniStream[:root.size]
+		}
+	}
+
+	return f, nil
+}
+
+// parseCFBEntry decodes a 128 byte directory entry
+func parseCFBEntry(b []byte) cfbEntry {
+	le := binary.LittleEndian
+	nameLen := int(le.Uint16(b[64:]))
+	if nameLen > 64 {
+		nameLen = 64
+	}
+	name := make([]uint16, 0, 32)
+	for i := 0; i+1 < nameLen; i += 2 {
+		if c := le.Uint16(b[i:]); c != 0 {
+			name = append(name, c)
+		}
+	}
+	return cfbEntry{
+		name:  string(utf16.Decode(name)),
+		typ:   b[66],
+		left:  le.Uint32(b[68:]),
+		right: le.Uint32(b[72:]),
+		child: le.Uint32(b[76:]),
+		start: le.Uint32(b[116:]),
+		size:  le.Uint64(b[120:]),
+	}
+}
+
+// sector returns the contents of regular sector n
+func (f *cfbFile) sector(n uint32) ([]byte, error) {
+	off := (int64(n) + 1) * int64(f.sectorSize)
+	if n > cfbMaxRegSect || off >= int64(len(f.data)) {
+		return nil, fmt.Errorf("Compound file sector %v out of range", n)
+	}
+	if off+int64(f.sectorSize) > int64(len(f.data)) {
+		// Tolerate a truncated final sector
+		b := make([]byte, f.sectorSize)
+		copy(b, f.data[off:])
+		return b, nil
+	}
+	return f.data[off : off+int64(f.sectorSize)], nil
+}
+
+// chain returns the concatenated contents of the regular sector chain beginning at start
+func (f *cfbFile) chain(start uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	for s, n := start, 0; s != cfbEndOfChain; n++ {
+		if n > len(f.fat) || int(s) >= len(f.fat) {
+			return nil, fmt.Errorf("Invalid compound file sector chain at %v", s)
+		}
+		sector, err := f.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(sector)
+		s = f.fat[s]
+	}
+	return buf.Bytes(), nil
+}
+
+// miniChain returns the concatenated contents of the mini sector chain beginning at start
+func (f *cfbFile) miniChain(start uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	for s, n := start, 0; s != cfbEndOfChain; n++ {
+		off := int(s) * f.miniSectorSize
+		if n > len(f.miniFat) || int(s) >= len(f.miniFat) || off+f.miniSectorSize > len(f.miniStream) {
+			return nil, fmt.Errorf("Invalid compound file mini sector chain at %v", s)
+		}
+		buf.Write(f.miniStream[off : off+f.miniSectorSize])
+		s = f.miniFat[s]
+	}
+	return buf.Bytes(), nil
+}
+
+// stream returns the contents of the stream entry e
+func (f *cfbFile) stream(e *cfbEntry) ([]byte, error) {
+	if e.size == 0 {
+		return nil, nil
+	}
+	var data []byte
+	var err error
+	if e.size < f.miniCutoff {
+		data, err = f.miniChain(e.start)
+	} else {
+		data, err = f.chain(e.start)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < e.size {
+		return nil, fmt.Errorf("Compound file stream %q is truncated", e.name)
+	}
+	return data[:e.size], nil
+}
+
+// children returns the indexes of the entries contained in storage entry i
+func (f *cfbFile) children(i int) []int {
+	var result []int
+	seen := make(map[uint32]bool)
+	var walk func(n uint32)
+	walk = func(n uint32) {
+		if n == cfbNoStream || int(n) >= len(f.entries) || seen[n] {
+			return
+		}
+		seen[n] = true
+		e := &f.entries[n]
+		walk(e.left)
+		result = append(result, int(n))
+		walk(e.right)
+	}
+	walk(f.entries[i].child)
+	return result
+}
@@ -0,0 +1,165 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// testEntry describes a directory entry for buildCFB
+type testEntry struct {
+	name     string
+	typ      byte
+	data     []byte
+	children []int // Indexes of the child entries, linked as a right leaning tree
+}
+
+// utf16LE encodes s as UTF-16LE, as .msg string properties and CFB names are stored
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// buildCFB returns a compound file with 512 byte sectors holding the given entries, the first
+// of which must be the root.  Streams are stored in regular sectors, never the mini stream.
+func buildCFB(entries []testEntry) []byte {
+	const sectorSize = 512
+	le := binary.LittleEndian
+	var sectors [][]byte
+	var fat []uint32
+	alloc := func(data []byte) uint32 {
+		if len(data) == 0 {
+			return cfbEndOfChain
+		}
+		start := uint32(len(sectors))
+		for off := 0; off < len(data); off += sectorSize {
+			sector := make([]byte, sectorSize)
+			copy(sector, data[off:])
+			sectors = append(sectors, sector)
+			fat = append(fat, uint32(len(sectors)))
+		}
+		fat[len(fat)-1] = cfbEndOfChain
+		return start
+	}
+
+	dir := make([]byte, cfbDirEntrySize*len(entries))
+	for i, e := range entries {
+		b := dir[cfbDirEntrySize*i:]
+		name := utf16LE(e.name)
+		copy(b, name)
+		le.PutUint16(b[64:], uint16(len(name)+2))
+		b[66] = e.typ
+		le.PutUint32(b[68:], cfbNoStream)
+		le.PutUint32(b[72:], cfbNoStream)
+		le.PutUint32(b[76:], cfbNoStream)
+		start := uint32(cfbEndOfChain)
+		if e.typ == cfbTypeStream {
+			start = alloc(e.data)
+		}
+		le.PutUint32(b[116:], start)
+		le.PutUint64(b[120:], uint64(len(e.data)))
+	}
+	for i, e := range entries {
+		for j, c := range e.children {
+			if j == 0 {
+				le.PutUint32(dir[cfbDirEntrySize*i+76:], uint32(c))
+			} else {
+				le.PutUint32(dir[cfbDirEntrySize*e.children[j-1]+72:], uint32(c))
+			}
+		}
+	}
+	dirStart := alloc(dir)
+
+	// A single FAT sector, marked as such in the FAT itself
+	fatSector := uint32(len(sectors))
+	fat = append(fat, 0xFFFFFFFD)
+	fatData := bytes.Repeat([]byte{0xFF}, sectorSize)
+	for i, v := range fat {
+		le.PutUint32(fatData[4*i:], v)
+	}
+	sectors = append(sectors, fatData)
+
+	header := make([]byte, cfbHeaderSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], 1)
+	le.PutUint32(header[0x30:], dirStart)
+	le.PutUint32(header[0x3C:], cfbEndOfChain)
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(header[0x4C+4*i:], cfbFreeSect)
+	}
+	le.PutUint32(header[0x4C:], fatSector)
+
+	out := bytes.NewBuffer(header)
+	for _, s := range sectors {
+		out.Write(s)
+	}
+	return out.Bytes()
+}
+
+func TestOpenCFB(t *testing.T) {
+	data := buildCFB([]testEntry{
+		{name: "Root Entry", typ: cfbTypeRoot, children: []int{1, 2}},
+		{name: "small", typ: cfbTypeStream, data: []byte("hello")},
+		{name: "large", typ: cfbTypeStream, data: bytes.Repeat([]byte("0123456789"), 200)},
+	})
+	if !isCFB(data) {
+		t.Fatal("isCFB() = false for a compound file")
+	}
+	f, err := openCFB(data)
+	if err != nil {
+		t.Fatalf("openCFB() error: %v", err)
+	}
+	children := f.children(0)
+	if len(children) != 2 {
+		t.Fatalf("children(0) = %v, want 2 entries", children)
+	}
+	want := map[string]int{"small": 5, "large": 2000}
+	for _, i := range children {
+		e := &f.entries[i]
+		data, err := f.stream(e)
+		if err != nil {
+			t.Errorf("stream(%q) error: %v", e.name, err)
+			continue
+		}
+		if len(data) != want[e.name] {
+			t.Errorf("stream(%q) has %v bytes, want %v", e.name, len(data), want[e.name])
+		}
+	}
+}
+
+func TestOpenCFBInvalid(t *testing.T) {
+	data := buildCFB([]testEntry{
+		{name: "Root Entry", typ: cfbTypeRoot, children: []int{1}},
+		{name: "stream", typ: cfbTypeStream, data: []byte("hello")},
+	})
+	badShift := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(badShift[0x1E:], 7)
+	badFAT := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(badFAT[0x4C:], 1000)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"signature only", data[:len(cfbSignature)]},
+		{"truncated header", data[:cfbHeaderSize-1]},
+		{"header only", data[:cfbHeaderSize]},
+		{"missing FAT sector", data[:len(data)-512]},
+		{"sector size", badShift},
+		{"FAT sector out of range", badFAT},
+	}
+	for _, tt := range tests {
+		if _, err := openCFB(tt.data); err == nil {
+			t.Errorf("%v: openCFB() returned no error", tt.name)
+		}
+	}
+}
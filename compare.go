@@ -0,0 +1,172 @@
+package enmime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffKind classifies a Difference between two MIMEPart trees.
+type DiffKind int
+
+const (
+	DiffHeaderAdded   DiffKind = iota // A header field is only present in b
+	DiffHeaderRemoved                 // A header field is only present in a
+	DiffHeaderChanged                 // A header field has different values
+	DiffPartAdded                     // A part is only present in b
+	DiffPartRemoved                   // A part is only present in a
+	DiffContentType                   // The parts have different content types
+	DiffContent                       // The parts have different decoded content
+)
+
+// String returns a short name for the kind of difference
+func (k DiffKind) String() string {
+	switch k {
+	case DiffHeaderAdded:
+		return "header-added"
+	case DiffHeaderRemoved:
+		return "header-removed"
+	case DiffHeaderChanged:
+		return "header-changed"
+	case DiffPartAdded:
+		return "part-added"
+	case DiffPartRemoved:
+		return "part-removed"
+	case DiffContentType:
+		return "content-type"
+	case DiffContent:
+		return "content"
+	}
+	return "unknown"
+}
+
+// Difference is one way in which two MIMEPart trees differ.
+type Difference struct {
+	Kind   DiffKind
+	Path   string // Position of the part, numbered like PartID; "" for the root
+	Header string // Name of the header field, for header differences
+	A      string // The value in a: header values, content type, or content size and hash
+	B      string // The value in b
+}
+
+// String formats the difference for test failures and logs
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "root"
+	}
+	if d.Header != "" {
+		return fmt.Sprintf("%v %v %v: %q -> %q", path, d.Kind, d.Header, d.A, d.B)
+	}
+	return fmt.Sprintf("%v %v: %v -> %v", path, d.Kind, d.A, d.B)
+}
+
+// Compare reports the differences between the trees rooted at a and b, in depth first order.
+// Children are matched by position, and the boundary parameter of multipart Content-Type
+// fields is ignored, as it is regenerated each time a tree is written.  Identical trees give
+// no differences.
+func Compare(a, b MIMEPart) []Difference {
+	var diffs []Difference
+	comparePart(a, b, "", &diffs)
+	return diffs
+}
+
+// comparePart compares a and b, which are at path, and their descendants
+func comparePart(a, b MIMEPart, path string, diffs *[]Difference) {
+	compareHeaders(a.Header(), b.Header(), path, diffs)
+	if a.ContentType() != b.ContentType() {
+		*diffs = append(*diffs, Difference{Kind: DiffContentType, Path: path,
+			A: a.ContentType(), B: b.ContentType()})
+	}
+	if ac, bc := a.Content(), b.Content(); !bytes.Equal(ac, bc) {
+		*diffs = append(*diffs, Difference{Kind: DiffContent, Path: path,
+			A: contentSummary(ac), B: contentSummary(bc)})
+	}
+
+	prefix := path
+	if prefix != "" {
+		prefix += "."
+	}
+	ac, bc := a.FirstChild(), b.FirstChild()
+	for n := 1; ac != nil || bc != nil; n++ {
+		childPath := prefix + strconv.Itoa(n)
+		switch {
+		case bc == nil:
+			*diffs = append(*diffs, Difference{Kind: DiffPartRemoved, Path: childPath,
+				A: ac.ContentType()})
+			ac = ac.NextSibling()
+		case ac == nil:
+			*diffs = append(*diffs, Difference{Kind: DiffPartAdded, Path: childPath,
+				B: bc.ContentType()})
+			bc = bc.NextSibling()
+		default:
+			comparePart(ac, bc, childPath, diffs)
+			ac, bc = ac.NextSibling(), bc.NextSibling()
+		}
+	}
+}
+
+// compareHeaders records the fields that differ between a and b, sorted by name
+func compareHeaders(a, b textproto.MIMEHeader, path string, diffs *[]Difference) {
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		if isParamField(a, k) || isParamField(b, k) {
+			// Copied from the Content-Type by the parser, compared as part of it
+			continue
+		}
+		av, aok := a[k]
+		bv, bok := b[k]
+		d := Difference{Path: path, Header: k, A: strings.Join(av, ", "), B: strings.Join(bv, ", ")}
+		switch {
+		case !aok:
+			d.Kind = DiffHeaderAdded
+		case !bok:
+			d.Kind = DiffHeaderRemoved
+		case k == "Content-Type" && len(av) == 1 && len(bv) == 1:
+			if withoutBoundary(av[0]) == withoutBoundary(bv[0]) {
+				continue
+			}
+			d.Kind = DiffHeaderChanged
+		case d.A == d.B && len(av) == len(bv):
+			continue
+		default:
+			d.Kind = DiffHeaderChanged
+		}
+		*diffs = append(*diffs, d)
+	}
+}
+
+// withoutBoundary returns a Content-Type value with its boundary parameter removed, in a
+// normalized form
+func withoutBoundary(ctype string) string {
+	mediatype, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return ctype
+	}
+	delete(params, "boundary")
+	return mime.FormatMediaType(mediatype, params)
+}
+
+// contentSummary describes content by its size and a prefix of its SHA-256 hash
+func contentSummary(content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("%v bytes sha256:%v", len(content), hex.EncodeToString(sum[:8]))
+}
@@ -0,0 +1,123 @@
+package enmime
+
+import (
+	"net/mail"
+	"net/textproto"
+)
+
+// DeepCopy returns a copy of this part and all of its descendants.  Headers and content are
+// copied, so the copy may be modified without affecting the original tree, except that
+// content held by a Storage is shared.  The copy is detached: its Parent and NextSibling are
+// nil.
+func (p *memMIMEPart) DeepCopy() MIMEPart {
+	return copyPart(p, nil, nil)
+}
+
+// DeepCopy returns a copy of the message and its MIMEPart tree.  The Attachments and Inlines
+// of the copy refer to parts in the copied tree, not the original, or to copies of their own
+// for parts outside the tree, such as those found by ExtractEncodedBlocks.
+func (m *MIMEBody) DeepCopy() *MIMEBody {
+	c := *m
+	c.Warnings = append([]Warning(nil), m.Warnings...)
+	c.header = mail.Header(copyHeader(textproto.MIMEHeader(m.header)))
+	if m.rawBody != nil {
+		c.rawBody = append([]byte(nil), m.rawBody...)
+	}
+	c.Scans = append([]ScanResult(nil), m.Scans...)
+
+	// Map each original part to its copy so the match lists can be rebuilt
+	copies := make(map[MIMEPart]MIMEPart)
+	if m.Root != nil {
+		c.Root = copyChild(m.Root, nil, copies)
+	}
+	c.Attachments = copyPartList(m.Attachments, copies)
+	c.Inlines = copyPartList(m.Inlines, copies)
+
+	return &c
+}
+
+// copyPart recursively copies p and its children, attaching the copy to parent.  If copies
+// is not nil, every copied descendant is recorded in it keyed by the original.
+func copyPart(p *memMIMEPart, parent MIMEPart, copies map[MIMEPart]MIMEPart) *memMIMEPart {
+	c := *p
+	c.parent = parent
+	c.firstChild = nil
+	c.nextSibling = nil
+	c.header = copyHeader(p.header)
+	if p.content != nil {
+		c.content = append([]byte(nil), p.content...)
+		c.shared = false
+	}
+	c.scans = append([]ScanResult(nil), p.scans...)
+	if p.rawContent != nil {
+		c.rawContent = append([]byte(nil), p.rawContent...)
+	}
+
+	var prev *memMIMEPart
+	for child := p.firstChild; child != nil; child = child.NextSibling() {
+		cc := copyChild(child, &c, copies)
+		if prev == nil {
+			c.firstChild = cc
+		} else {
+			prev.nextSibling = cc
+		}
+		prev = cc
+	}
+
+	return &c
+}
+
+// copyChild copies p as a child of parent, recording the copy in copies if it is not nil.
+// Other implementations of MIMEPart are copied into a memMIMEPart through their accessors,
+// so every part of the copied tree can be linked to its siblings.
+func copyChild(p MIMEPart, parent MIMEPart, copies map[MIMEPart]MIMEPart) *memMIMEPart {
+	mp, ok := p.(*memMIMEPart)
+	if !ok {
+		mp = &memMIMEPart{
+			firstChild:  p.FirstChild(),
+			header:      p.Header(),
+			contentType: p.ContentType(),
+			disposition: p.Disposition(),
+			fileName:    p.FileName(),
+			partID:      p.PartID(),
+			content:     p.Content(),
+			rawContent:  p.RawContent(),
+			err:         p.Error(),
+			scans:       p.ScanResults(),
+		}
+	}
+	c := copyPart(mp, parent, copies)
+	if copies != nil {
+		copies[p] = c
+	}
+	return c
+}
+
+// copyPartList replaces each part in the list with its copy, copying parts that are not in
+// the tree
+func copyPartList(parts []MIMEPart, copies map[MIMEPart]MIMEPart) []MIMEPart {
+	if parts == nil {
+		return nil
+	}
+	result := make([]MIMEPart, len(parts))
+	for i, p := range parts {
+		c, ok := copies[p]
+		if !ok && p != nil {
+			c = copyChild(p, nil, copies)
+		}
+		result[i] = c
+	}
+	return result
+}
+
+// copyHeader returns a copy of the header, including each value slice
+func copyHeader(h textproto.MIMEHeader) textproto.MIMEHeader {
+	if h == nil {
+		return nil
+	}
+	c := make(textproto.MIMEHeader, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,88 @@
+package enmime
+
+import (
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// DispositionParams are the file attributes a Content-Disposition header may carry, RFC 2183
+// section 2.  Zero values are absent.
+type DispositionParams struct {
+	FileName         string
+	CreationDate     time.Time
+	ModificationDate time.Time
+	ReadDate         time.Time
+	Size             int64 // Approximate size of the file in bytes
+}
+
+// GetDispositionParams returns the Content-Disposition parameters of p.  Dates that cannot be
+// parsed are left zero.
+func GetDispositionParams(p MIMEPart) DispositionParams {
+	d := DispositionParams{FileName: p.FileName()}
+	_, params, err := mime.ParseMediaType(p.Header().Get("Content-Disposition"))
+	if err != nil {
+		return d
+	}
+	for k, t := range map[string]*time.Time{
+		"creation-date":     &d.CreationDate,
+		"modification-date": &d.ModificationDate,
+		"read-date":         &d.ReadDate,
+	} {
+		if v, ok := params[k]; ok {
+			*t, _ = mail.ParseDate(v)
+		}
+	}
+	if size, err := strconv.ParseInt(params["size"], 10, 64); err == nil && size > 0 {
+		d.Size = size
+	}
+	return d
+}
+
+// FormatDisposition returns a Content-Disposition header value for disposition, such as
+// "attachment", with the parameters that are set in params.
+func FormatDisposition(disposition string, params DispositionParams) string {
+	dparams := make(map[string]string)
+	if params.FileName != "" {
+		dparams["filename"] = params.FileName
+	}
+	for k, t := range map[string]time.Time{
+		"creation-date":     params.CreationDate,
+		"modification-date": params.ModificationDate,
+		"read-date":         params.ReadDate,
+	} {
+		if !t.IsZero() {
+			dparams[k] = t.Format(time.RFC1123Z)
+		}
+	}
+	if params.Size > 0 {
+		dparams["size"] = strconv.FormatInt(params.Size, 10)
+	}
+	return mime.FormatMediaType(disposition, dparams)
+}
+
+// NewAttachmentPart returns a detached attachment part holding content, with a content type
+// guessed from params.FileName and the other params in its Content-Disposition.
+func NewAttachmentPart(content []byte, params DispositionParams) *memMIMEPart {
+	ctype := "application/octet-stream"
+	if mediatype, _, err := mime.ParseMediaType(mime.TypeByExtension(
+		filepath.Ext(params.FileName))); err == nil {
+		ctype = mediatype
+	}
+	p := NewMIMEPart(nil, ctype)
+	p.disposition = "attachment"
+	p.fileName = params.FileName
+	p.content = content
+	p.header = make(textproto.MIMEHeader)
+	var cparams map[string]string
+	if params.FileName != "" {
+		cparams = map[string]string{"name": params.FileName}
+	}
+	p.header.Set("Content-Type", mime.FormatMediaType(ctype, cparams))
+	p.header.Set("Content-Disposition", FormatDisposition("attachment", params))
+	p.header.Set("Content-Transfer-Encoding", "base64")
+	return p
+}
@@ -0,0 +1,316 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteMIME writes the MIMEPart tree rooted at p to w in its wire format.  Each part's header
+// is written sorted by field name, followed by its content encoded according to its
+// Content-Transfer-Encoding header.  Multipart parts are given a newly generated boundary,
+// see wireHeader for the other changes to headers.
+func WriteMIME(w io.Writer, p MIMEPart) error {
+	return WriteMIMEWithOptions(w, p, nil)
+}
+
+// WriteOptions controls optional behavior of WriteMIMEWithOptions.  The zero value gives the
+// behavior of WriteMIME.
+type WriteOptions struct {
+	// PartSigner, if set, is given the rendered root entity: its Content-* fields and body,
+	// exactly as they will be written.  The entity is wrapped in a multipart/signed along with
+	// the returned signature, and the other fields of the root header move to the wrapper.
+	// S/MIME and PGP/MIME signers fit here.
+	PartSigner func(entity []byte) (*PartSignature, error)
+
+	// HeaderSigner, if set, is given the complete rendered message, after any PartSigner has
+	// run.  The header fields it returns, such as a DKIM-Signature, are written before the
+	// message.
+	HeaderSigner func(message []byte) ([]byte, error)
+
+	// Boundary, if set, generates the boundary of each multipart in place of the random
+	// default; SeededBoundaries gives a repeatable sequence.
+	Boundary func() string
+
+	// Deterministic makes the output byte for byte repeatable, for golden file tests:
+	// boundaries come from SeededBoundaries(0) unless Boundary is set, and Date and
+	// Message-Id fields of the root are replaced by DeterministicDate and
+	// DeterministicMessageID.
+	Deterministic bool
+}
+
+// The Date and Message-Id written in deterministic mode.
+var (
+	DeterministicDate      = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	DeterministicMessageID = "<deterministic@enmime.invalid>"
+)
+
+// encoder holds the options of a single WriteMIMEWithOptions call
+type encoder struct {
+	opts     WriteOptions
+	boundary func() string
+}
+
+// PartSignature is the result of a WriteOptions.PartSigner.
+type PartSignature struct {
+	Part     MIMEPart // The signature part, such as application/pkcs7-signature
+	Protocol string   // The protocol parameter of the multipart/signed, the type of Part
+	MicAlg   string   // The micalg parameter of the multipart/signed, such as "sha-256"
+}
+
+// WriteMIMEWithOptions is like WriteMIME, with optional behavior controlled by opts.  When
+// signing, the message is rendered to memory once and the signatures are added around that
+// rendering, so what was signed is what is written.
+func WriteMIMEWithOptions(w io.Writer, p MIMEPart, opts *WriteOptions) error {
+	e := &encoder{boundary: newBoundary}
+	if opts != nil {
+		e.opts = *opts
+	}
+	if e.opts.Boundary != nil {
+		e.boundary = e.opts.Boundary
+	} else if e.opts.Deterministic {
+		e.boundary = SeededBoundaries(0)
+	}
+	header := wireHeader(p)
+	if e.opts.Deterministic {
+		if header.Get("Date") != "" {
+			header.Set("Date", DeterministicDate.Format(time.RFC1123Z))
+		}
+		if header.Get("Message-Id") != "" {
+			header.Set("Message-Id", DeterministicMessageID)
+		}
+	}
+
+	if e.opts.PartSigner == nil && e.opts.HeaderSigner == nil {
+		bw := bufio.NewWriter(w)
+		if err := e.writeEntity(bw, p, header); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	var msg bytes.Buffer
+	bw := bufio.NewWriter(&msg)
+	if e.opts.PartSigner != nil {
+		if err := e.writeSigned(bw, p, header); err != nil {
+			return err
+		}
+	} else if err := e.writeEntity(bw, p, header); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if e.opts.HeaderSigner != nil {
+		fields, err := e.opts.HeaderSigner(msg.Bytes())
+		if err != nil {
+			return fmt.Errorf("Error signing message: %v", err)
+		}
+		if len(fields) > 0 && !bytes.HasSuffix(fields, []byte("\n")) {
+			fields = append(fields, "\r\n"...)
+		}
+		if _, err := w.Write(fields); err != nil {
+			return err
+		}
+	}
+	_, err := msg.WriteTo(w)
+	return err
+}
+
+// writeSigned writes p with the given header wrapped in a multipart/signed, signing the
+// rendered entity with the PartSigner
+func (e *encoder) writeSigned(w *bufio.Writer, p MIMEPart, header textproto.MIMEHeader) error {
+	outer := make(textproto.MIMEHeader)
+	inner := make(textproto.MIMEHeader)
+	for k, v := range header {
+		if strings.HasPrefix(k, "Content-") {
+			inner[k] = v
+		} else {
+			outer[k] = v
+		}
+	}
+
+	var entity bytes.Buffer
+	ew := bufio.NewWriter(&entity)
+	if err := e.writeEntity(ew, p, inner); err != nil {
+		return err
+	}
+	if err := ew.Flush(); err != nil {
+		return err
+	}
+	sig, err := e.opts.PartSigner(entity.Bytes())
+	if err != nil {
+		return fmt.Errorf("Error signing message: %v", err)
+	}
+	if sig == nil || sig.Part == nil {
+		return fmt.Errorf("PartSigner returned no signature")
+	}
+
+	boundary := e.boundary()
+	params := map[string]string{"protocol": sig.Protocol, "boundary": boundary}
+	if sig.MicAlg != "" {
+		params["micalg"] = sig.MicAlg
+	}
+	outer.Set("Content-Type", mime.FormatMediaType("multipart/signed", params))
+	writeHeader(w, outer)
+	fmt.Fprintf(w, "--%s\r\n", boundary)
+	w.Write(entity.Bytes())
+	fmt.Fprintf(w, "\r\n--%s\r\n", boundary)
+	if err := e.writePart(w, sig.Part); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\r\n--%s--\r\n", boundary)
+	return err
+}
+
+// writePart recursively writes p to w
+func (e *encoder) writePart(w *bufio.Writer, p MIMEPart) error {
+	return e.writeEntity(w, p, wireHeader(p))
+}
+
+// wireHeader returns a copy of the header of p fit to be written with its content.  The
+// Content-Type parameters the parser copies into the header are dropped, and content the
+// parser converted to UTF-8 or decompressed is labelled as such.
+func wireHeader(p MIMEPart) textproto.MIMEHeader {
+	header := copyHeader(p.Header())
+	for k := range header {
+		if isParamField(header, k) {
+			delete(header, k)
+		}
+	}
+	mp, ok := p.(*memMIMEPart)
+	if !ok {
+		return header
+	}
+	if mp.charset != "" {
+		mediatype, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil {
+			mediatype, params = p.ContentType(), make(map[string]string)
+		}
+		params["charset"] = "utf-8"
+		header.Set("Content-Type", mime.FormatMediaType(mediatype, params))
+	}
+	if mp.decompressed {
+		header.Del("Content-Encoding")
+	}
+	return header
+}
+
+// writeEntity writes p to w using the given header, which it may modify, in place of the
+// part's own
+func (e *encoder) writeEntity(w *bufio.Writer, p MIMEPart, header textproto.MIMEHeader) error {
+	if header == nil {
+		header = make(map[string][]string)
+	}
+
+	var boundary string
+	if strings.HasPrefix(p.ContentType(), "multipart/") {
+		_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil {
+			params = make(map[string]string)
+		}
+		boundary = e.boundary()
+		params["boundary"] = boundary
+		header.Set("Content-Type", mime.FormatMediaType(p.ContentType(), params))
+	} else if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", p.ContentType())
+	}
+
+	writeHeader(w, header)
+
+	if boundary == "" {
+		return writeContent(w, header.Get("Content-Transfer-Encoding"), p.Content())
+	}
+	for c := p.FirstChild(); c != nil; c = c.NextSibling() {
+		fmt.Fprintf(w, "--%s\r\n", boundary)
+		if err := e.writePart(w, c); err != nil {
+			return err
+		}
+		w.WriteString("\r\n")
+	}
+	_, err := fmt.Fprintf(w, "--%s--\r\n", boundary)
+	return err
+}
+
+// writeHeader writes the header fields sorted by name, followed by the blank line ending the
+// header
+func writeHeader(w *bufio.Writer, header textproto.MIMEHeader) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+	w.WriteString("\r\n")
+}
+
+// writeContent writes content to w using the named transfer encoding
+func writeContent(w io.Writer, encoding string, content []byte) error {
+	switch strings.ToLower(encoding) {
+	case "base64":
+		buf := make([]byte, base64.StdEncoding.EncodedLen(len(content)))
+		base64.StdEncoding.Encode(buf, content)
+		for len(buf) > 0 {
+			n := 76
+			if n > len(buf) {
+				n = len(buf)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, "\r\n"); err != nil {
+				return err
+			}
+			buf = buf[n:]
+		}
+		return nil
+	case "quoted-printable":
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(content); err != nil {
+			return err
+		}
+		return qp.Close()
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// SeededBoundaries returns a boundary generator for WriteOptions.Boundary producing the same
+// sequence of random looking boundaries for the same seed.
+func SeededBoundaries(seed int64) func() string {
+	var n uint64
+	return func() string {
+		var buf [16]byte
+		binary.BigEndian.PutUint64(buf[:8], uint64(seed))
+		binary.BigEndian.PutUint64(buf[8:], n)
+		n++
+		sum := sha256.Sum256(buf[:])
+		return "enmime-" + hex.EncodeToString(sum[:24])
+	}
+}
+
+// newBoundary returns a random multipart boundary
+func newBoundary() string {
+	var buf [24]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+	return "enmime-" + hex.EncodeToString(buf[:])
+}
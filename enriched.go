@@ -0,0 +1,287 @@
+package enmime
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// EnrichedToText converts an RFC 1896 text/enriched body to plain text, removing formatting
+// commands and applying its line break rules.
+func EnrichedToText(enriched string) string {
+	return renderEnriched(enriched, false, false)
+}
+
+// EnrichedToHTML converts an RFC 1896 text/enriched body to an HTML fragment.
+func EnrichedToHTML(enriched string) string {
+	return renderEnriched(enriched, false, true)
+}
+
+// RichtextToText converts an RFC 1341 text/richtext body to plain text.
+func RichtextToText(richtext string) string {
+	return renderEnriched(richtext, true, false)
+}
+
+// RichtextToHTML converts an RFC 1341 text/richtext body to an HTML fragment.
+func RichtextToHTML(richtext string) string {
+	return renderEnriched(richtext, true, true)
+}
+
+// convertEnriched converts a text/enriched or text/richtext body to text or HTML
+func convertEnriched(mediatype, body string, toHTML bool) string {
+	return renderEnriched(body, mediatype == "text/richtext", toHTML)
+}
+
+// enrichedTags maps formatting commands to the HTML elements they open and close
+var enrichedTags = map[string][2]string{
+	"bold":        {"<b>", "</b>"},
+	"italic":      {"<i>", "</i>"},
+	"underline":   {"<u>", "</u>"},
+	"fixed":       {"<tt>", "</tt>"},
+	"smaller":     {"<small>", "</small>"},
+	"bigger":      {"<big>", "</big>"},
+	"center":      {`<div style="text-align:center">`, "</div>"},
+	"flushleft":   {`<div style="text-align:left">`, "</div>"},
+	"flushright":  {`<div style="text-align:right">`, "</div>"},
+	"flushboth":   {`<div style="text-align:justify">`, "</div>"},
+	"indent":      {`<div style="margin-left:2em">`, "</div>"},
+	"indentright": {`<div style="margin-right:2em">`, "</div>"},
+	"excerpt":     {"<blockquote>", "</blockquote>"},
+	"nofill":      {"<pre>", "</pre>"},
+	"subscript":   {"<sub>", "</sub>"},
+	"superscript": {"<sup>", "</sup>"},
+	"heading":     {"<h3>", "</h3>"},
+}
+
+// enrichedParamTags are commands whose HTML element depends on the following <param>
+var enrichedParamTags = map[string]bool{"color": true, "fontfamily": true}
+
+// enrichedRenderer holds the state of a text/enriched conversion
+type enrichedRenderer struct {
+	out      bytes.Buffer
+	html     bool
+	richtext bool
+	stack    []string // Open commands
+	nofill   int      // Depth of nofill commands
+	hidden   int      // Depth of commands whose content is not displayed
+	param    bytes.Buffer
+	pending  string // Command awaiting its <param> before its HTML element is written
+}
+
+// renderEnriched converts text/enriched, or text/richtext, to text or HTML
+func renderEnriched(input string, richtext, toHTML bool) string {
+	r := &enrichedRenderer{html: toHTML, richtext: richtext}
+	input = strings.Replace(input, "\r\n", "\n", -1)
+
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == '<':
+			if !richtext && i+1 < len(input) && input[i+1] == '<' {
+				// Doubled << is a literal <
+				r.text("<")
+				i++
+				continue
+			}
+			end := strings.IndexByte(input[i:], '>')
+			if end < 0 || end > 62 || !isEnrichedCommand(input[i+1:i+end]) {
+				r.text("<")
+				continue
+			}
+			r.command(strings.ToLower(input[i+1 : i+end]))
+			i += end
+		case c == '\n':
+			if r.nofill > 0 {
+				r.newline()
+				continue
+			}
+			if richtext {
+				// Line breaks are whitespace in richtext, <nl> forces one
+				r.text(" ")
+				continue
+			}
+			// Runs of n newlines produce n-1 line breaks, a single one is a space
+			n := 1
+			for i+1 < len(input) && input[i+1] == '\n' {
+				n++
+				i++
+			}
+			if n == 1 {
+				r.text(" ")
+			}
+			for ; n > 1; n-- {
+				r.newline()
+			}
+		default:
+			j := i
+			for j < len(input) && input[j] != '<' && input[j] != '\n' {
+				j++
+			}
+			r.text(input[i:j])
+			i = j - 1
+		}
+	}
+
+	r.flushPending()
+	if r.html {
+		for len(r.stack) > 0 {
+			r.close(r.stack[len(r.stack)-1])
+		}
+	}
+	return r.out.String()
+}
+
+// isEnrichedCommand returns true if name is a valid, optionally negated, command name
+func isEnrichedCommand(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// command processes an opening or closing formatting command
+func (r *enrichedRenderer) command(name string) {
+	if r.richtext {
+		switch name {
+		case "lt":
+			r.text("<")
+			return
+		case "nl":
+			r.newline()
+			return
+		case "np":
+			r.newline()
+			r.newline()
+			return
+		}
+	}
+
+	// Content of <param>, or of richtext <comment>, is not displayed
+	hide := "param"
+	if r.richtext {
+		hide = "comment"
+	}
+	switch {
+	case name == hide:
+		r.hidden++
+		return
+	case name == "/"+hide && r.hidden > 0:
+		r.hidden--
+		if r.hidden == 0 {
+			r.flushPending()
+			r.param.Reset()
+		}
+		return
+	case r.hidden > 0:
+		return
+	}
+
+	if strings.HasPrefix(name, "/") {
+		// Close the most recent matching command, and any left open inside of it
+		name = name[1:]
+		for i := len(r.stack) - 1; i >= 0; i-- {
+			if r.stack[i] == name {
+				for len(r.stack) > i {
+					r.close(r.stack[len(r.stack)-1])
+				}
+				break
+			}
+		}
+		return
+	}
+
+	r.flushPending()
+	r.stack = append(r.stack, name)
+	if name == "nofill" {
+		r.nofill++
+	}
+	if !r.html {
+		return
+	}
+	if enrichedParamTags[name] {
+		r.pending = name
+		return
+	}
+	if tags, ok := enrichedTags[name]; ok {
+		r.out.WriteString(tags[0])
+	}
+}
+
+// close pops the top command from the stack and writes its HTML end tag
+func (r *enrichedRenderer) close(name string) {
+	r.flushPending()
+	r.stack = r.stack[:len(r.stack)-1]
+	if name == "nofill" && r.nofill > 0 {
+		r.nofill--
+	}
+	if !r.html {
+		return
+	}
+	if enrichedParamTags[name] {
+		r.out.WriteString("</span>")
+	} else if tags, ok := enrichedTags[name]; ok {
+		r.out.WriteString(tags[1])
+	}
+}
+
+// flushPending writes the HTML element for a command that takes a parameter, using the
+// parameter collected so far
+func (r *enrichedRenderer) flushPending() {
+	if r.pending == "" {
+		return
+	}
+	// Only allow characters that cannot escape the style attribute
+	value := strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			return c
+		case strings.ContainsRune(" ,#-", c):
+			return c
+		}
+		return -1
+	}, strings.TrimSpace(r.param.String()))
+	switch {
+	case value == "":
+		r.out.WriteString("<span>")
+	case r.pending == "color":
+		r.out.WriteString(`<span style="color:` + value + `">`)
+	default:
+		r.out.WriteString(`<span style="font-family:` + value + `">`)
+	}
+	r.pending = ""
+}
+
+// text writes displayed text, or collects it as a parameter
+func (r *enrichedRenderer) text(s string) {
+	if r.hidden > 0 {
+		if !r.richtext {
+			r.param.WriteString(s)
+		}
+		return
+	}
+	r.flushPending()
+	if r.html {
+		s = html.EscapeString(s)
+	}
+	r.out.WriteString(s)
+}
+
+// newline writes a line break
+func (r *enrichedRenderer) newline() {
+	if r.hidden > 0 {
+		return
+	}
+	r.flushPending()
+	if r.html && r.nofill == 0 {
+		r.out.WriteString("<br>")
+	}
+	r.out.WriteString("\n")
+}
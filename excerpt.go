@@ -0,0 +1,137 @@
+package enmime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"code.google.com/p/mahonia"
+)
+
+// ExcerptOptions sets the budget of ReadExcerpt.  A zero limit is no limit.
+type ExcerptOptions struct {
+	MaxBytes int  // Maximum length of the excerpt in UTF-8 bytes
+	MaxRunes int  // Maximum length of the excerpt in characters
+	HTML     bool // Take the text/html body rather than text/plain
+}
+
+// Excerpt is the beginning of the text or HTML body of a message.
+type Excerpt struct {
+	Text        string // Decoded UTF-8 text, never ending in a partial character
+	ContentType string // Type of the body taken, "" if the message has none
+	Truncated   bool   // The body continues past the budget
+}
+
+// ReadExcerpt reads the first text/plain body of the message, or text/html if opts.HTML is
+// set, up to the budget of opts.  Parts before it are skipped rather than decoded, and reading
+// stops at the budget, so it is suited to indexers which only want the beginning of large
+// messages.  Attachments are never taken as the body.
+func ReadExcerpt(mailMsg *mail.Message, opts *ExcerptOptions) (*Excerpt, error) {
+	if opts == nil {
+		opts = &ExcerptOptions{}
+	}
+	want := "text/plain"
+	if opts.HTML {
+		want = "text/html"
+	}
+	return readExcerpt(textproto.MIMEHeader(mailMsg.Header), mailMsg.Body, want, opts, 0)
+}
+
+// readExcerpt takes the excerpt from the entity with header and body, searching multiparts
+// depth first
+func readExcerpt(header textproto.MIMEHeader, body io.Reader, want string,
+	opts *ExcerptOptions, depth int) (*Excerpt, error) {
+	ctype := header.Get("Content-Type")
+	if ctype == "" {
+		ctype = "text/plain"
+	}
+	mediatype, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse media type: %v", err)
+	}
+
+	if strings.HasPrefix(mediatype, "multipart/") {
+		if depth >= maxPartDepth {
+			return nil, fmt.Errorf("Multipart nesting exceeds %v levels", maxPartDepth)
+		}
+		boundary := params["boundary"]
+		if boundary == "" {
+			return nil, fmt.Errorf("Unable to locate boundary param in Content-Type header")
+		}
+		mr := multipart.NewReader(body, boundary)
+		for {
+			mrp, err := mr.NextRawPart()
+			if err == io.EOF {
+				return &Excerpt{}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			e, err := readExcerpt(mrp.Header, mrp, want, opts, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if e.ContentType != "" {
+				return e, nil
+			}
+		}
+	}
+
+	if mediatype != want || depth > 0 && isAttachment(header) {
+		return &Excerpt{}, nil
+	}
+	var decoder io.Reader
+	if strings.EqualFold(header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		decoder = qpDecoder(QPModeAuto, mediatype, body)
+	} else {
+		decoder = transferDecoder(header.Get("Content-Transfer-Encoding"), body)
+	}
+	decoder, err = newParser(nil).contentDecoder(header.Get("Content-Encoding"), decoder)
+	if err != nil {
+		return nil, err
+	}
+	if charset := params["charset"]; charset != "" {
+		cs := mahonia.GetCharset(charset)
+		if cs == nil {
+			return nil, fmt.Errorf("Unknown (to mahonia) charset: %q", charset)
+		}
+		decoder = cs.NewDecoder().NewReader(decoder)
+	}
+	text, truncated, err := readBudget(bufio.NewReader(decoder), opts.MaxBytes, opts.MaxRunes)
+	if err != nil {
+		return nil, err
+	}
+	return &Excerpt{Text: text, ContentType: mediatype, Truncated: truncated}, nil
+}
+
+// isAttachment returns true if header marks its part as an attachment
+func isAttachment(header textproto.MIMEHeader) bool {
+	disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	return disposition == "attachment"
+}
+
+// readBudget reads whole characters from r until maxBytes or maxRunes would be exceeded,
+// reporting whether any remain
+func readBudget(r *bufio.Reader, maxBytes, maxRunes int) (string, bool, error) {
+	var b strings.Builder
+	for runes := 0; ; runes++ {
+		c, _, err := r.ReadRune()
+		if err == io.EOF {
+			return b.String(), false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if maxRunes > 0 && runes >= maxRunes ||
+			maxBytes > 0 && b.Len()+utf8.RuneLen(c) > maxBytes {
+			return b.String(), true, nil
+		}
+		b.WriteRune(c)
+	}
+}
@@ -0,0 +1,84 @@
+package enmime
+
+import (
+	"mime"
+)
+
+// typeExtensions maps content types to their conventional file name extension, for the
+// common types where mime.ExtensionsByType offers several or none.
+var typeExtensions = map[string]string{
+	"application/gzip":                 ".gz",
+	"application/ics":                  ".ics",
+	"application/json":                 ".json",
+	"application/msword":               ".doc",
+	"application/octet-stream":         ".bin",
+	"application/pdf":                  ".pdf",
+	"application/pgp-encrypted":        ".pgp",
+	"application/pgp-keys":             ".asc",
+	"application/pgp-signature":        ".asc",
+	"application/pkcs7-mime":           ".p7m",
+	"application/pkcs7-signature":      ".p7s",
+	"application/rtf":                  ".rtf",
+	"application/vnd.ms-excel":         ".xls",
+	"application/vnd.ms-outlook":       ".msg",
+	"application/vnd.ms-powerpoint":    ".ppt",
+	"application/vnd.ms-tnef":          ".dat",
+	"application/x-7z-compressed":      ".7z",
+	"application/x-gzip":               ".gz",
+	"application/x-pkcs7-mime":         ".p7m",
+	"application/x-pkcs7-signature":    ".p7s",
+	"application/x-rar-compressed":     ".rar",
+	"application/x-tar":                ".tar",
+	"application/xml":                  ".xml",
+	"application/zip":                  ".zip",
+	"audio/mpeg":                       ".mp3",
+	"audio/ogg":                        ".ogg",
+	"audio/wav":                        ".wav",
+	"image/bmp":                        ".bmp",
+	"image/gif":                        ".gif",
+	"image/heic":                       ".heic",
+	"image/jpeg":                       ".jpg",
+	"image/pjpeg":                      ".jpg",
+	"image/png":                        ".png",
+	"image/svg+xml":                    ".svg",
+	"image/tiff":                       ".tif",
+	"image/webp":                       ".webp",
+	"message/delivery-status":          ".txt",
+	"message/disposition-notification": ".txt",
+	"message/rfc822":                   ".eml",
+	"text/calendar":                    ".ics",
+	"text/csv":                         ".csv",
+	"text/enriched":                    ".txt",
+	"text/html":                        ".html",
+	"text/plain":                       ".txt",
+	"text/rfc822-headers":              ".txt",
+	"text/richtext":                    ".rtx",
+	"text/vcard":                       ".vcf",
+	"text/x-vcard":                     ".vcf",
+	"text/xml":                         ".xml",
+	"video/mp4":                        ".mp4",
+	"video/mpeg":                       ".mpeg",
+	"video/quicktime":                  ".mov",
+
+	// Office Open XML
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         ".xlsx",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   ".docx",
+}
+
+// ExtensionForType suggests a file name extension, including the leading dot, for content
+// of the given type, such as ".jpg" for image/jpeg.  Parameters are ignored.  It returns ""
+// if the type is not known to enmime or the mime package.
+func ExtensionForType(contentType string) string {
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := typeExtensions[mediatype]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediatype); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package enmime
+
+import (
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// Features summarizes the structure of a message for spam and phishing classifiers.  The
+// counts are cheap to compute from the parsed message and are stable across parses.
+type Features struct {
+	PartTypes       map[string]int // Number of parts of each content type, multiparts included
+	TextLength      int            // Length of the plain text body in bytes
+	HTMLLength      int            // Length of the HTML body in bytes
+	TextHTMLRatio   float64        // TextLength / HTMLLength, 0 if there is no HTML body
+	RemoteImages    int            // <img> tags loading an http, https or protocol relative URL
+	CIDImages       int            // <img> tags referring to a related part by cid:
+	DataImages      int            // <img> tags with inline data: URLs
+	AttachmentTypes map[string]int // Number of attachments of each content type
+	Charsets        map[string]int // Number of text parts declaring each charset, lower case
+	// Number of RFC 2047 encoded-words in the raw Subject header
+	SubjectEncodedWords int
+	// Share of the raw Subject length taken up by encoded-words, from 0 to 1
+	SubjectEncodedDensity float64
+	// Number of warnings of each category recorded during the parse, boundary-close and
+	// boundary-reuse among them
+	Anomalies map[WarningCategory]int
+}
+
+var encodedWordRE = regexp.MustCompile(`=\?[^?\s]+\?[BbQq]\?[^?\s]*\?=`)
+
+// Features computes the feature summary of the message.
+func (m *MIMEBody) Features() Features {
+	f := Features{
+		PartTypes:       make(map[string]int),
+		AttachmentTypes: make(map[string]int),
+		Charsets:        make(map[string]int),
+		Anomalies:       make(map[WarningCategory]int),
+		TextLength:      len(m.Text),
+		HTMLLength:      len(m.Html),
+	}
+	if f.HTMLLength > 0 {
+		f.TextHTMLRatio = float64(f.TextLength) / float64(f.HTMLLength)
+	}
+
+	if m.Root != nil {
+		DepthMatchAll(m.Root, func(p MIMEPart) bool {
+			f.countPart(p.ContentType(), p.Header().Get("Content-Type"))
+			return false
+		})
+	} else {
+		// Text only message, the header describes its single part
+		ctype := m.header.Get("Content-Type")
+		mediatype, _, _ := mime.ParseMediaType(ctype)
+		if mediatype == "" {
+			mediatype = "text/plain"
+		}
+		f.countPart(mediatype, ctype)
+	}
+	for _, a := range m.Attachments {
+		f.AttachmentTypes[a.ContentType()]++
+	}
+
+	anyURL := func(string) bool { return true }
+	for _, ref := range htmlRefs(m.Html, anyURL) {
+		if ref.Tag != "img" || ref.Attr != "src" {
+			continue
+		}
+		src := strings.ToLower(ref.URL)
+		switch {
+		case strings.HasPrefix(src, "cid:"):
+			f.CIDImages++
+		case strings.HasPrefix(src, "data:"):
+			f.DataImages++
+		case strings.HasPrefix(src, "http:"), strings.HasPrefix(src, "https:"),
+			strings.HasPrefix(src, "//"):
+			f.RemoteImages++
+		}
+	}
+
+	subject := m.header.Get("Subject")
+	if words := encodedWordRE.FindAllStringIndex(subject, -1); len(words) > 0 {
+		encoded := 0
+		for _, w := range words {
+			encoded += w[1] - w[0]
+		}
+		f.SubjectEncodedWords = len(words)
+		f.SubjectEncodedDensity = float64(encoded) / float64(len(subject))
+	}
+
+	for _, w := range m.Warnings {
+		f.Anomalies[w.Category]++
+	}
+	return f
+}
+
+// countPart records a part of the given media type and raw Content-Type header
+func (f *Features) countPart(mediatype, ctype string) {
+	f.PartTypes[mediatype]++
+	if !strings.HasPrefix(mediatype, "text/") {
+		return
+	}
+	charset := "us-ascii"
+	if _, params, err := mime.ParseMediaType(ctype); err == nil && params["charset"] != "" {
+		charset = strings.ToLower(params["charset"])
+	}
+	f.Charsets[charset]++
+}
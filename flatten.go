@@ -0,0 +1,103 @@
+package enmime
+
+import (
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// Flatten returns a copy of the tree rooted at p with redundant multipart wrappers removed,
+// leaving p untouched.  Generators often nest multiparts gratuitously, such as a
+// multipart/mixed holding only a multipart/alternative; the simpler tree displays the same.
+//
+// A multipart/mixed, alternative or related with a single child is replaced by that child,
+// which inherits the wrapper's fields other than Content-*, so the From and Subject of a root
+// wrapper are kept.  A multipart/mixed directly inside another has its children spliced into
+// the outer one.  Signed and encrypted multiparts are never changed, as that would break
+// their signatures.  Part IDs are renumbered to match the new structure.
+//
+// Only the wrappers within maxDepth levels of p, p itself being the first, are flattened;
+// those nested deeper are left as they are.  A maxDepth of 0, or one beyond the nesting the
+// parser accepts, flattens the whole tree.
+func Flatten(p MIMEPart, maxDepth int) MIMEPart {
+	if maxDepth <= 0 || maxDepth > maxPartDepth {
+		maxDepth = maxPartDepth
+	}
+	root := flattenPart(copyChild(p, nil, nil), maxDepth)
+	root.parent = nil
+	assignPartIDs(root, "")
+	return root
+}
+
+// flattenPart flattens the descendants of p within depth levels, then p itself, returning its
+// replacement
+func flattenPart(p *memMIMEPart, depth int) *memMIMEPart {
+	if depth <= 0 {
+		return p
+	}
+	var children []*memMIMEPart
+	for c := p.firstChild; c != nil; {
+		next := c.NextSibling()
+		fc := flattenPart(c.(*memMIMEPart), depth-1)
+		if p.contentType == "multipart/mixed" && fc.contentType == "multipart/mixed" &&
+			fc.disposition == "" && onlyContentFields(fc) {
+			for gc := fc.firstChild; gc != nil; gc = gc.NextSibling() {
+				children = append(children, gc.(*memMIMEPart))
+			}
+		} else {
+			children = append(children, fc)
+		}
+		c = next
+	}
+	p.firstChild = nil
+	for _, c := range children {
+		appendChild(p, c)
+	}
+
+	if len(children) != 1 {
+		return p
+	}
+	switch p.contentType {
+	case "multipart/mixed", "multipart/alternative", "multipart/related":
+	default:
+		return p
+	}
+	child := children[0]
+	for k, v := range p.header {
+		if !isContentField(p, k) && child.header.Get(k) == "" {
+			if child.header == nil {
+				child.header = make(map[string][]string)
+			}
+			child.header[k] = v
+		}
+	}
+	child.parent = p.parent
+	child.nextSibling = nil
+	return child
+}
+
+// onlyContentFields returns true if the header of p only has fields describing its content
+func onlyContentFields(p *memMIMEPart) bool {
+	for k := range p.header {
+		if !isContentField(p, k) {
+			return false
+		}
+	}
+	return true
+}
+
+// isContentField returns true if the header field k of p describes its content: a Content-*
+// field, or one of the Content-Type parameters the parser copies into the header
+func isContentField(p *memMIMEPart, k string) bool {
+	return strings.HasPrefix(k, "Content-") || isParamField(p.header, k)
+}
+
+// isParamField returns true if the header field k is one of the Content-Type parameters the
+// parser copies into the header, rather than a field of the message
+func isParamField(header textproto.MIMEHeader, k string) bool {
+	if strings.HasPrefix(k, "Content-") {
+		return false
+	}
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && params[strings.ToLower(k)] != ""
+}
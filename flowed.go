@@ -0,0 +1,218 @@
+package enmime
+
+import (
+	"mime"
+	"strings"
+)
+
+// ContentLanguage returns the language tags of p from its Content-Language header, such as
+// "en-US", or nil if it has none.
+func ContentLanguage(p MIMEPart) []string {
+	var tags []string
+	for _, v := range p.Header()["Content-Language"] {
+		for _, tag := range strings.Split(v, ",") {
+			// Drop comments, which RFC 3282 allows between tags
+			if i := strings.IndexByte(tag, '('); i >= 0 {
+				tag = tag[:i]
+			}
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// FlowedFormat reports whether p is text/plain with the format=flowed parameter of RFC 3676,
+// and whether it also has delsp=yes.  Flowed text should be unwrapped with UnwrapFlowed
+// before display.
+func FlowedFormat(p MIMEPart) (flowed, delsp bool) {
+	if p.ContentType() != "text/plain" {
+		return false, false
+	}
+	_, params, err := mime.ParseMediaType(p.Header().Get("Content-Type"))
+	if err != nil || !strings.EqualFold(params["format"], "flowed") {
+		return false, false
+	}
+	return true, strings.EqualFold(params["delsp"], "yes")
+}
+
+// UnwrapFlowed joins the soft line breaks of format=flowed text, giving paragraphs that can be
+// wrapped to the width of the display.  A line ending in a space continues on the next line
+// of the same quote depth; with delsp that space is removed.  Space stuffing is undone, and
+// quoted lines are written with one ">" per level followed by a space.  The line endings of
+// text are kept.
+func UnwrapFlowed(text string, delsp bool) string {
+	eol := "\n"
+	if strings.Contains(text, "\r\n") {
+		eol = "\r\n"
+	}
+	trailing := strings.HasSuffix(text, "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+
+	var out []string
+	var para strings.Builder
+	depth, open := 0, false
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		d := 0
+		for d < len(line) && line[d] == '>' {
+			d++
+		}
+		content := strings.TrimPrefix(line[d:], " ")
+		if open && d != depth {
+			// A change of quote depth ends the paragraph, even after a soft break
+			out = append(out, para.String())
+			para.Reset()
+			open = false
+		}
+		if !open {
+			depth = d
+			if d > 0 {
+				para.WriteString(strings.Repeat(">", d) + " ")
+			}
+		}
+		// The signature separator is not a soft break
+		soft := strings.HasSuffix(content, " ") && content != "-- "
+		if soft && delsp {
+			content = content[:len(content)-1]
+		}
+		para.WriteString(content)
+		if open = soft; !open {
+			out = append(out, para.String())
+			para.Reset()
+		}
+	}
+	if open {
+		out = append(out, para.String())
+	}
+
+	result := strings.Join(out, eol)
+	if trailing {
+		result += eol
+	}
+	return result
+}
+
+// DefaultFlowedWidth is the line length FlowText wraps to when given a width of zero, the
+// RFC 3676 recommendation.
+const DefaultFlowedWidth = 72
+
+// NewFlowedTextPart returns a UTF-8 text/plain part holding text in format=flowed, with the
+// Content-Type parameters set to match.  Each line of text is a paragraph, which receiving
+// clients reflow to fit their display.
+func NewFlowedTextPart(text string, delsp bool) *memMIMEPart {
+	p := newTextPart("text/plain", FlowText(text, 0, delsp))
+	params := map[string]string{"charset": "utf-8", "format": "flowed"}
+	if delsp {
+		params["delsp"] = "yes"
+	}
+	p.header.Set("Content-Type", mime.FormatMediaType("text/plain", params))
+	return p
+}
+
+// FlowText encodes text as format=flowed, wrapping each line longer than width with soft line
+// breaks; UnwrapFlowed reverses it.  Lines starting with ">" are treated as quoted and keep
+// their quote depth when wrapped.  Lines that would be misread are space stuffed, and
+// trailing white space, which would read as a soft break, is removed.  With delsp, words
+// longer than the width are split as well, suiting languages written without spaces.
+func FlowText(text string, width int, delsp bool) string {
+	if width <= 0 {
+		width = DefaultFlowedWidth
+	}
+	eol := "\n"
+	if strings.Contains(text, "\r\n") {
+		eol = "\r\n"
+	}
+	trailing := strings.HasSuffix(text, "\n")
+
+	var out []string
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		depth := 0
+		for depth < len(line) && line[depth] == '>' {
+			depth++
+		}
+		prefix := strings.Repeat(">", depth)
+		content := line[depth:]
+		if depth > 0 {
+			prefix += " "
+			content = strings.TrimPrefix(content, " ")
+		}
+		if content != "-- " {
+			content = strings.TrimRight(content, " \t")
+		}
+		for _, l := range flowLine(content, width-len(prefix), delsp) {
+			if depth == 0 && (strings.HasPrefix(l, " ") || strings.HasPrefix(l, ">") ||
+				strings.HasPrefix(l, "From ")) {
+				l = " " + l
+			}
+			out = append(out, prefix+l)
+		}
+	}
+
+	result := strings.Join(out, eol)
+	if trailing {
+		result += eol
+	}
+	return result
+}
+
+// flowLine wraps one paragraph into lines of at most width bytes where possible, every line
+// but the last ending in the space that marks a soft break
+func flowLine(content string, width int, delsp bool) []string {
+	if width < 1 {
+		width = 1
+	}
+	if len(content) <= width || content == "-- " {
+		return []string{content}
+	}
+
+	// Each word keeps the spaces following it, so joining the lines restores the paragraph
+	var words []string
+	for rest := content; rest != ""; {
+		i := strings.IndexByte(rest, ' ')
+		if i < 0 {
+			words = append(words, rest)
+			break
+		}
+		j := i
+		for j < len(rest) && rest[j] == ' ' {
+			j++
+		}
+		words = append(words, rest[:j])
+		rest = rest[j:]
+	}
+
+	var lines []string
+	var cur string
+	for _, w := range words {
+		if cur != "" && len(cur)+len(strings.TrimRight(w, " ")) > width {
+			lines = append(lines, cur)
+			cur = ""
+		}
+		for delsp && len(w) > width {
+			// Split by runes, the soft break space is added below
+			n := 0
+			for _, r := range w {
+				if n+len(string(r)) > width-1 && n > 0 {
+					break
+				}
+				n += len(string(r))
+			}
+			lines = append(lines, w[:n])
+			w = w[n:]
+		}
+		cur += w
+	}
+	if cur != "" {
+		lines = append(lines, cur)
+	}
+
+	for i := range lines[:len(lines)-1] {
+		if delsp || !strings.HasSuffix(lines[i], " ") {
+			lines[i] += " "
+		}
+	}
+	return lines
+}
@@ -0,0 +1,136 @@
+package enmime
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+)
+
+// FormFileHandler is called by ParseFormData for each file part, with a reader that streams
+// its decoded content.  The reader returns an error if the file exceeds MaxFileSize.  The
+// handler must consume the content before returning; returning an error stops the parse.
+type FormFileHandler func(part MIMEPart, r io.Reader) error
+
+// FormDataOptions controls how ParseFormData treats field values and files.  A zero limit
+// means no limit.
+type FormDataOptions struct {
+	MaxValueSize int64           // Limit on the combined size of all non-file values
+	MaxFileSize  int64           // Limit on the size of each file
+	FileHandler  FormFileHandler // Receives file content; if nil it is stored in the part
+}
+
+// FormData is the result of parsing a multipart/form-data body.
+type FormData struct {
+	Root   MIMEPart              // The multipart/form-data MIMEPart holding all fields
+	Values map[string][]string   // Values of the non-file fields, keyed by field name
+	Files  map[string][]MIMEPart // File parts, keyed by field name
+}
+
+// ParseFormData parses a multipart/form-data body, such as an HTTP upload, from r.
+// contentType is the value of the Content-Type header that carries the boundary.  Each
+// field becomes a child MIMEPart of Root with a disposition of "form-data".  File parts are
+// streamed to opts.FileHandler when it is set, so that large uploads need not be held in
+// memory.  opts may be nil.
+func ParseFormData(r io.Reader, contentType string, opts *FormDataOptions) (*FormData, error) {
+	if opts == nil {
+		opts = &FormDataOptions{}
+	}
+	mediatype, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse media type: %v", err)
+	}
+	if mediatype != "multipart/form-data" {
+		return nil, fmt.Errorf("Unexpected mediatype: %v", mediatype)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("Unable to locate boundary param in Content-Type header")
+	}
+
+	root := NewMIMEPart(nil, mediatype)
+	form := &FormData{
+		Root:   root,
+		Values: make(map[string][]string),
+		Files:  make(map[string][]MIMEPart),
+	}
+	valueBudget := &sizeLimitReader{limit: opts.MaxValueSize, what: "Form field data"}
+
+	var prevSibling *memMIMEPart
+	fields := 0
+	mr := multipart.NewReader(r, boundary)
+	for {
+		mrp, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := mrp.FormName()
+		if name == "" {
+			return nil, fmt.Errorf("Missing form field name at boundary %v", boundary)
+		}
+
+		p := NewMIMEPart(root, "text/plain")
+		p.header = mrp.Header
+		fields++
+		p.partID = strconv.Itoa(fields)
+		p.disposition = "form-data"
+		p.fileName = mrp.FileName()
+		charset := ""
+		if ctype := mrp.Header.Get("Content-Type"); ctype != "" {
+			if mediatype, mparams, err := mime.ParseMediaType(ctype); err == nil {
+				p.contentType = mediatype
+				charset = mparams["charset"]
+			}
+		} else if p.fileName != "" {
+			p.contentType = "application/octet-stream"
+		}
+		if prevSibling != nil {
+			prevSibling.nextSibling = p
+		} else {
+			root.firstChild = p
+		}
+		prevSibling = p
+
+		cr := &countingReader{r: mrp}
+		decoder := transferDecoder(mrp.Header.Get("Content-Transfer-Encoding"), cr)
+		if p.fileName == "" && !strings.Contains(mrp.Header.Get("Content-Disposition"),
+			"filename") {
+			// Plain field value, charged against the shared value budget
+			if opts.MaxValueSize > 0 {
+				valueBudget.r = decoder
+				decoder = valueBudget
+			}
+			value, err := decodeSection("", charset, decoder)
+			if err != nil {
+				return nil, err
+			}
+			p.content = value
+			p.rawSize, p.rawLines = cr.bytes, cr.lines
+			form.Values[name] = append(form.Values[name], string(value))
+			continue
+		}
+
+		if opts.MaxFileSize > 0 {
+			decoder = &sizeLimitReader{r: decoder, limit: opts.MaxFileSize,
+				what: fmt.Sprintf("File %q", p.fileName)}
+		}
+		if opts.FileHandler != nil {
+			if err := opts.FileHandler(p, decoder); err != nil {
+				return nil, err
+			}
+		} else {
+			if p.content, err = decodeSection("", "", decoder); err != nil {
+				return nil, err
+			}
+		}
+		p.rawSize, p.rawLines = cr.bytes, cr.lines
+		form.Files[name] = append(form.Files[name], p)
+	}
+
+	return form, nil
+}
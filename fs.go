@@ -0,0 +1,181 @@
+package enmime
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Open implements fs.FS, exposing the message's attachments, and any inlines having a file
+// name, as files in a flat directory.  File names are sanitized so they are valid fs.FS
+// paths; unnamed parts are called "part-N" with an extension from ExtensionForType, and
+// repeated names are given a numeric suffix.  The opened files implement io.ReaderAt and
+// io.Seeker, and FileInfo.Sys returns the MIMEPart.
+func (m *MIMEBody) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &attachmentDir{info: m.dirInfo(), entries: m.dirEntries()}, nil
+	}
+	for _, f := range m.attachmentFiles() {
+		if f.name == name {
+			content := f.part.Content()
+			f.size = int64(len(content))
+			return &attachmentFile{Reader: bytes.NewReader(content), info: f}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS, listing the files available through Open sorted by name.
+func (m *MIMEBody) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	files := m.dirEntries()
+	entries := make([]fs.DirEntry, len(files))
+	for i, f := range files {
+		entries[i] = f
+	}
+	return entries, nil
+}
+
+// attachmentFiles returns the parts exposed through Open along with their file names
+func (m *MIMEBody) attachmentFiles() []*attachmentInfo {
+	parts := append([]MIMEPart(nil), m.Attachments...)
+	for _, p := range m.Inlines {
+		if p.FileName() != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	modTime, _ := m.header.Date()
+	used := make(map[string]bool)
+	files := make([]*attachmentInfo, 0, len(parts))
+	for i, p := range parts {
+		name := sanitizeFileName(p.FileName())
+		if name == "" {
+			name = "part-" + strconv.Itoa(i+1) + ExtensionForType(p.ContentType())
+		}
+		if used[name] {
+			ext := path.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			for n := 2; used[name]; n++ {
+				name = base + " (" + strconv.Itoa(n) + ")" + ext
+			}
+		}
+		used[name] = true
+		files = append(files, &attachmentInfo{name: name, size: -1, modTime: modTime, part: p})
+	}
+	return files
+}
+
+// dirEntries returns the files of the root directory sorted by name, as fs.ReadDirFS requires
+func (m *MIMEBody) dirEntries() []*attachmentInfo {
+	files := m.attachmentFiles()
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files
+}
+
+// dirInfo returns the FileInfo of the root directory
+func (m *MIMEBody) dirInfo() *attachmentInfo {
+	modTime, _ := m.header.Date()
+	return &attachmentInfo{name: ".", modTime: modTime, dir: true}
+}
+
+// sanitizeFileName reduces a file name taken from a message to a single safe path element,
+// or the empty string if nothing usable remains.
+func sanitizeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 32, r == 127:
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	return name
+}
+
+// attachmentInfo describes a file or the root directory, implementing fs.FileInfo and
+// fs.DirEntry
+type attachmentInfo struct {
+	name    string
+	size    int64 // Size of the content, -1 until Size reads it
+	modTime time.Time
+	part    MIMEPart
+	dir     bool
+}
+
+func (i *attachmentInfo) Name() string               { return i.name }
+func (i *attachmentInfo) ModTime() time.Time         { return i.modTime }
+func (i *attachmentInfo) IsDir() bool                { return i.dir }
+func (i *attachmentInfo) Sys() interface{}           { return i.part }
+func (i *attachmentInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *attachmentInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// Size returns the size of the content, which is read the first time it is needed
+func (i *attachmentInfo) Size() int64 {
+	if i.size < 0 {
+		i.size = int64(len(i.part.Content()))
+	}
+	return i.size
+}
+
+func (i *attachmentInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// attachmentFile is an open attachment, its content is read from memory
+type attachmentFile struct {
+	*bytes.Reader
+	info *attachmentInfo
+}
+
+func (f *attachmentFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *attachmentFile) Close() error               { return nil }
+
+// attachmentDir is the open root directory
+type attachmentDir struct {
+	info    *attachmentInfo
+	entries []*attachmentInfo
+	offset  int
+}
+
+func (d *attachmentDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *attachmentDir) Close() error               { return nil }
+
+func (d *attachmentDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *attachmentDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(remaining) {
+		remaining = remaining[:n]
+	}
+	d.offset += len(remaining)
+	entries := make([]fs.DirEntry, len(remaining))
+	for i, f := range remaining {
+		entries[i] = f
+	}
+	return entries, nil
+}
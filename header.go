@@ -1,323 +1,247 @@
 package enmime
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"code.google.com/p/mahonia"
 )
 
-func debug(format string, args ...interface{}) {
-	if false {
-		fmt.Printf(format, args...)
-		fmt.Println()
-	}
-}
-
 // Terminology from RFC 2047:
 //  encoded-word: the entire =?charset?encoding?encoded-text?= string
 //  charset: the character set portion of the encoded word
 //  encoding: the character encoding type used for the encoded-text
 //  encoded-text: the text we are decoding
 
-// State function modeled on Rob Pike's lexer talk (see source of
-// Go's text/template/parser/lex.go)
-type stateFn func(*headerDec) stateFn
-
-const eof = -1
-
-// headerDec holds the state of the scanner and an output buffer
-type headerDec struct {
-	input    []byte  // Input to decode
-	state    stateFn // Next state
-	start    int     // Start of text we don't yet know what to do with
-	pos      int     // Current parsing position
-	charset  string  // Character set of current encoded word
-	encoding string  // Encoding of current encoded word
-	outbuf   bytes.Buffer
+// maxEncodedWord limits the length of an encoded-word, longer ones are passed through as
+// plain text.  RFC 2047 allows 75 characters.
+const maxEncodedWord = 4096
+
+// HeaderDecoder is a reader decoding the RFC 2047 encoded-words in a header value, such as a
+// Subject or file name made of hundreds of them.  Its memory use does not grow with the
+// length of the value.  Adjacent words in the same charset are joined before conversion, so
+// a character split between them is decoded.  Malformed words, and words in unknown
+// charsets, are passed through unchanged.
+type HeaderDecoder struct {
+	r         *bufio.Reader
+	warn      func(Warning) // Called for encoded words that fail to decode, can be nil
+	out       bytes.Buffer  // Decoded text not yet read
+	space     []byte        // White space after an encoded-word, dropped if another follows
+	afterWord bool          // The last token was an encoded-word, perhaps followed by space
+	canStart  bool          // An encoded-word may start here, which it may not within text
+	charset   string        // Charset of pending
+	decoder   mahonia.Decoder
+	pending   []byte // Decoded bytes of the last encoded-words not yet forming a character
+	err       error
 }
 
-// eof returns true if we've read the last rune
-func (h *headerDec) eof() bool {
-	return h.pos >= len(h.input)
+// NewHeaderDecoder returns a HeaderDecoder reading the undecoded value from r.
+func NewHeaderDecoder(r io.Reader) *HeaderDecoder {
+	return newHeaderDecoder(r, nil)
 }
 
-// next returns the next rune in the input
-func (h *headerDec) next() rune {
-	if h.eof() {
-		return eof
-	}
-	r := h.input[h.pos]
-	h.pos++
-	return rune(r)
+// newHeaderDecoder returns a HeaderDecoder calling warn for each word that fails to decode
+func newHeaderDecoder(r io.Reader, warn func(Warning)) *HeaderDecoder {
+	return &HeaderDecoder{r: bufio.NewReader(r), warn: warn, canStart: true}
 }
 
-// backup a single rune
-func (h *headerDec) backup() {
-	if h.pos > 0 {
-		h.pos--
+// Read method for io.Reader interface.
+func (d *HeaderDecoder) Read(p []byte) (int, error) {
+	for d.out.Len() == 0 && d.err == nil {
+		d.err = d.step()
 	}
-}
-
-// peek at the next rune without consuming it
-func (h *headerDec) peek() rune {
-	if h.eof() {
-		return eof
+	if d.out.Len() > 0 {
+		return d.out.Read(p)
 	}
-	r := h.next()
-	h.backup()
-	return r
+	return 0, d.err
 }
 
-// ignore will forget all input between start and pos
-func (h *headerDec) ignore() {
-	h.start = h.pos
-}
-
-// output will append all input from start to pos (inclusive) to outbuf
-func (h *headerDec) output() {
-	if h.pos > h.start {
-		h.outbuf.Write(h.input[h.start:h.pos])
-		h.start = h.pos
+// step decodes the next token of the input: white space, an encoded-word or a run of text
+func (d *HeaderDecoder) step() error {
+	if next, _ := d.r.Peek(2); d.canStart && string(next) == "=?" {
+		d.r.Discard(2)
+		return d.word()
 	}
-}
-
-// accept consumes the next rune if it's part of the valid set
-func (h *headerDec) accept(valid string) bool {
-	if r := h.next(); r != eof {
-		if strings.IndexRune(valid, r) >= 0 {
-			return true
+	c, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			d.flush()
 		}
-		h.backup()
-	}
-	return false
-}
-
-// Decode a MIME header per RFC 2047
-func decodeHeader(input string) string {
-	if !strings.Contains(input, "=?") {
-		// Don't scan if there is nothing to do here
-		return input
+		return err
 	}
-
-	h := &headerDec{
-		input: []byte(input),
-		state: plainSpaceState,
+	switch {
+	case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		if d.afterWord {
+			d.space = append(d.space, c)
+		} else {
+			d.out.WriteByte(c)
+		}
+		d.canStart = true
+		return nil
+	case c == '(':
+		// The start of a comment, an encoded-word may follow directly
+		d.flush()
+		d.out.WriteByte(c)
+		d.canStart = true
+		return nil
 	}
+	d.r.UnreadByte()
+	return d.text()
+}
 
-	debug("Starting parse of: '%v'\n", input)
-
-	for h.state != nil {
-		h.state = h.state(h)
+// text copies plain text up to the next white space or comment
+func (d *HeaderDecoder) text() error {
+	d.flush()
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '(' {
+			d.r.UnreadByte()
+			d.canStart = false
+			return nil
+		}
+		d.out.WriteByte(c)
 	}
-
-	return h.outbuf.String()
 }
 
-// State: Reset, output mangled encoded-word as plaintext
-//
-// There was a problem parsing an encoded-word, recover by outputting
-// plain-text until next encoded word
-func resetState(h *headerDec) stateFn {
-	debug("entering reset state with buf %q", h.outbuf.String())
-	h.output()
-	return plainTextState
-}
+// word decodes an encoded-word following its "=?", or passes it through as text if it is
+// malformed
+func (d *HeaderDecoder) word() error {
+	raw := []byte("=?")
+	fail := func(err error) error {
+		// Output what was read as text, up to the next white space or comment
+		d.flush()
+		d.out.Write(raw)
+		if err != nil {
+			return err
+		}
+		return d.text()
+	}
 
-// State: In plain space - we want to output this space, and it is legal to transition into
-// an encoded word from here.
-func plainSpaceState(h *headerDec) stateFn {
-	debug("entering plain space state with buf %q", h.outbuf.String())
-	for !h.eof() {
+	// Read charset?encoding?encoded-text?=
+	var fields [3][]byte
+	for i := 0; i < 3; {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return fail(err)
+		}
 		switch {
-		case h.accept("="):
-			// Possible encoded word, dump out preceeding plaintext, w/o leading =
-			h.backup()
-			h.output()
-			h.next()
-			if h.accept("?") {
-				return charsetState
+		case c == '?' && i < 2:
+			i++
+		case c == '?':
+			// Must terminate the word
+			if next, err := d.r.Peek(1); err != nil || next[0] != '=' {
+				raw = append(raw, c)
+				return fail(nil)
 			}
-		case h.accept(" \t\r\n("):
-			// '(' is the start of a MIME header "comment", so it is still legal to transition
-			// to an encoded word after it
+			d.r.ReadByte()
+			i++
+		case i < 2 && !isTokenChar(rune(c)), c < 33 || c > 126, len(raw) >= maxEncodedWord:
+			d.r.UnreadByte()
+			return fail(nil)
 		default:
-			// Hit some plain text
-			return plainTextState
+			fields[i] = append(fields[i], c)
 		}
+		raw = append(raw, c)
 	}
-	// Hitting EOF in plain space state means we are done
-	h.output()
-	return nil
-}
+	raw = append(raw, '=')
+	charset, encoding := string(fields[0]), string(fields[1])
 
-// State: In plain text - we want to output this text.  It is not legal to transition into
-// an encoded word from here!
-func plainTextState(h *headerDec) stateFn {
-	debug("entering plain text state with buf %q", h.outbuf.String())
-	for !h.eof() {
-		if h.accept(" \t\r\n(") {
-			// TODO Not sure if '(' belongs here, maybe require space first?
-			// Whitespace character
-			h.backup()
-			return plainSpaceState
+	decoded, err := decodeWordText(encoding, fields[2])
+	cs := mahonia.GetCharset(charset)
+	if cs == nil && err == nil {
+		err = fmt.Errorf("Unknown (to mahonia) charset: %q", charset)
+	}
+	if err != nil {
+		if d.warn != nil {
+			category := WarnHeaderDecode
+			if cs == nil {
+				category = WarnUnknownCharset
+			}
+			d.warn(Warning{Category: category, Value: string(raw), Message: err.Error()})
 		}
-		h.next()
+		return fail(nil)
 	}
-	// Hitting EOF in plain text state means we are done
-	h.output()
-	return nil
-}
 
-// State: In encoded-word charset name
-func charsetState(h *headerDec) stateFn {
-	debug("entering charset state with buf %q", h.outbuf.String())
-	myStart := h.pos
-	for r := h.next(); r != eof; r = h.next() {
-		// Parse character set
-		switch {
-		case isTokenChar(r):
-			// Part of charset name, keep going
-		case r == '?':
-			// End of charset name
-			h.charset = string(h.input[myStart : h.pos-1])
-			debug("charset %q", h.charset)
-			return encodingState
-		default:
-			// Invalid character
-			return resetState
-		}
+	// White space between adjacent encoded-words is dropped
+	d.space = d.space[:0]
+	if !d.afterWord || !strings.EqualFold(charset, d.charset) {
+		d.flush()
+		d.charset, d.decoder = charset, cs.NewDecoder()
 	}
-	// Hit eof!
-	return resetState
+	d.afterWord, d.canStart = true, true
+	d.pending = append(d.pending, decoded...)
+	d.convert(false)
+	return nil
 }
 
-// State: In encoded-word encoding name
-func encodingState(h *headerDec) stateFn {
-	debug("entering encoding state with buf %q", h.outbuf.String())
-	myStart := h.pos
-	for r := h.next(); r != eof; r = h.next() {
-		// Parse encoding
-		switch {
-		case isTokenChar(r):
-			// Part of encoding name, keep going
-		case r == '?':
-			// End of encoding name
-			h.encoding = string(h.input[myStart : h.pos-1])
-			debug("encoding %q", h.encoding)
-			return encTextState
-		default:
-			// Invalid character
-			debug("invalid character")
-			return resetState
-		}
-	}
-	// Hit eof!
-	debug("hit unexpected eof")
-	return resetState
+// flush converts the pending bytes and outputs the white space that followed them
+func (d *HeaderDecoder) flush() {
+	d.convert(true)
+	d.out.Write(d.space)
+	d.space = d.space[:0]
+	d.afterWord = false
 }
 
-// State: In encoded-text
-func encTextState(h *headerDec) stateFn {
-	debug("entering encText state with buf %q", h.outbuf.String())
-	myStart := h.pos
-	for r := h.next(); r != eof; r = h.next() {
-		// Decode encoded-text
-		switch {
-		case r < 33:
-			// No controls or space allowed
-			debug("Encountered control character")
-			return resetState
-		case r > 126:
-			// No DEL or extended ascii allowed
-			debug("Encountered DEL or extended ascii")
-			return resetState
-		case r == '?':
-			if h.accept("=") {
-				text, err := convertText(h.charset, h.encoding, h.input[myStart:h.pos-2])
-				if err == nil {
-					debug("Text converted to: %q", text)
-					h.outbuf.WriteString(text)
-					h.ignore()
-					// Entering post-word space
-					return spaceState
-				} else {
-					// Conversion failed
-					debug("Text conversion failed: %q", err)
-					return resetState
-				}
-			} else {
-				// Invalid termination
-				debug("Invalid termination")
-				return resetState
+// convert outputs the characters of pending, keeping a trailing partial character unless
+// final is set
+func (d *HeaderDecoder) convert(final bool) {
+	pos := 0
+	for pos < len(d.pending) {
+		c, size, status := d.decoder(d.pending[pos:])
+		if status == mahonia.NO_ROOM {
+			if !final {
+				break
 			}
+			c, size = utf8.RuneError, len(d.pending)-pos
+		}
+		if size <= 0 {
+			size = 1
 		}
+		if status != mahonia.STATE_ONLY {
+			d.out.WriteRune(c)
+		}
+		pos += size
 	}
-	// Hit eof!
-	debug("Hit eof early")
-	return resetState
+	d.pending = append(d.pending[:0], d.pending[pos:]...)
 }
 
-// State: White space following an encoded-word
-func spaceState(h *headerDec) stateFn {
-	debug("entering space state with buf %q", h.outbuf.String())
-Loop:
-	for {
-		// Eat space characters only between encoded words
-		switch {
-		case h.accept(" \t\r\n"):
-			debug("In space")
-			// Still in space
-		case h.accept("="):
-			debug("In =")
-			if h.accept("?") {
-				// Start of new encoded word.  If the word is valid, we want to eat
-				// the whitespace.  If not, h.start was set in transition to SPACE,
-				// and we will output the space.
-				return charsetState
-			}
-		default:
-			debug("In default")
-			break Loop
-		}
-	}
-	debug("In plain")
-	// We hit plain text, will need to output whitespace
-	h.output()
-	return plainTextState
+// Decode a MIME header per RFC 2047
+func decodeHeader(input string) string {
+	return decodeHeaderWarn(input, nil)
 }
 
-// Convert the encTextBytes to UTF-8 and return as a string
-func convertText(charsetName string, encoding string, encTextBytes []byte) (string, error) {
-	// Setup mahonia to convert bytes to UTF-8 string
-	charset := mahonia.GetCharset(charsetName)
-	if charset == nil {
-		// Unknown charset
-		return "", fmt.Errorf("Unknown (to mahonia) charset: %q", charsetName)
+// Decode a MIME header per RFC 2047, calling warn for each encoded word that could not be
+// decoded
+func decodeHeaderWarn(input string, warn func(Warning)) string {
+	if !strings.Contains(input, "=?") {
+		// Don't scan if there is nothing to do here
+		return input
 	}
-	decoder := charset.NewDecoder()
+	var b strings.Builder
+	io.Copy(&b, newHeaderDecoder(strings.NewReader(input), warn))
+	return b.String()
+}
 
-	// Unpack quoted-printable or base64 first
-	var textBytes []byte
-	var err error
+// decodeWordText unpacks the encoded-text of an encoded word
+func decodeWordText(encoding string, encTextBytes []byte) ([]byte, error) {
 	switch strings.ToLower(encoding) {
 	case "b":
 		// Base64 encoded
-		textBytes, err = decodeBase64(encTextBytes)
+		return decodeBase64(encTextBytes)
 	case "q":
 		// Quoted printable encoded
-		textBytes, err = decodeQuotedPrintable(encTextBytes)
-	default:
-		err = fmt.Errorf("Invalid encoding: %v", encoding)
-	}
-	if err != nil {
-		return "", err
+		return decodeQuotedPrintable(encTextBytes)
 	}
-
-	return decoder.ConvertString(string(textBytes)), nil
+	return nil, fmt.Errorf("Invalid encoding: %v", encoding)
 }
 
 func decodeQuotedPrintable(input []byte) ([]byte, error) {
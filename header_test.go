@@ -0,0 +1,75 @@
+package enmime
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "Hello there", "Hello there"},
+		{"Q encoding", "=?utf-8?q?Caf=C3=A9_au_lait?=", "Café au lait"},
+		{"B encoding", "=?UTF-8?B?aGVsbG8=?= world", "hello world"},
+		{"adjacent words joined", "=?utf-8?q?a?= \r\n =?utf-8?q?b?=", "ab"},
+		{"text between words", "=?utf-8?q?a?= x =?utf-8?q?b?=", "a x b"},
+		{"text before word", "Re: =?utf-8?q?hi?=", "Re: hi"},
+		{"word within text", "x=?utf-8?q?a?=", "x=?utf-8?q?a?="},
+		{"word in comment", "(=?utf-8?q?a?=)", "(a)"},
+		{"character split between words", "=?utf-8?b?w6k=?= =?utf-8?q?=C3?= =?utf-8?q?=A9x?=",
+			"ééx"},
+		{"unknown charset", "=?x-unknown?q?a?= b", "=?x-unknown?q?a?= b"},
+		{"unknown encoding", "=?utf-8?x?a?= b", "=?utf-8?x?a?= b"},
+		{"invalid Q encoding", "=?utf-8?q?=ZZ?= x", "=?utf-8?q?=ZZ?= x"},
+		{"space in word", "=?utf-8?q?a b?=", "=?utf-8?q?a b?="},
+		{"unterminated word", "=?utf-8?q?a", "=?utf-8?q?a"},
+		{"unterminated text", "=?utf-8?q?a?Q b", "=?utf-8?q?a?Q b"},
+		{"trailing space", "=?utf-8?q?a?= ", "a "},
+	}
+	for _, tt := range tests {
+		if got := decodeHeader(tt.input); got != tt.want {
+			t.Errorf("%v: decodeHeader(%q) = %q, want %q", tt.name, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeHeaderWarnings(t *testing.T) {
+	var warns []Warning
+	decodeHeaderWarn("=?x-unknown?q?a?= =?utf-8?q?=Z?= =?utf-8?q?ok?=", func(w Warning) {
+		warns = append(warns, w)
+	})
+	want := []WarningCategory{WarnUnknownCharset, WarnHeaderDecode}
+	if len(warns) != len(want) {
+		t.Fatalf("got %v warnings, want %v: %v", len(warns), len(want), warns)
+	}
+	for i, w := range warns {
+		if w.Category != want[i] {
+			t.Errorf("warning %v category = %q, want %q", i, w.Category, want[i])
+		}
+	}
+}
+
+func TestHeaderDecoderLong(t *testing.T) {
+	// A value made of thousands of words, each splitting a character with the next
+	var b strings.Builder
+	for i := 0; i < 2000; i++ {
+		b.WriteString("=?utf-8?q?ab=C3?= =?utf-8?q?=A9?= ")
+	}
+	got, err := io.ReadAll(NewHeaderDecoder(strings.NewReader(b.String())))
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+	if want := strings.Repeat("abé", 2000) + " "; string(got) != want {
+		t.Errorf("got %v bytes %q..., want %v bytes", len(got), got[:20], len(want))
+	}
+
+	// An encoded word longer than maxEncodedWord is passed through
+	long := "=?utf-8?q?" + strings.Repeat("a", maxEncodedWord) + "?="
+	if got := decodeHeader(long); got != long {
+		t.Errorf("decodeHeader() decoded a word of %v bytes", len(long))
+	}
+}
@@ -0,0 +1,271 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IMAPBodyStructure walks the MIMEPart tree rooted at p and returns its body structure as
+// specified by RFC 3501 section 7.4.2.  If extended is true the extension data is included,
+// as required for a BODYSTRUCTURE response; otherwise the result is suitable for a BODY
+// response.  Encapsulated message/rfc822 parts are parsed to produce their envelope and
+// nested body structure.
+func IMAPBodyStructure(p MIMEPart, extended bool) string {
+	b := new(bytes.Buffer)
+	writeBodyStructure(b, p, extended)
+	return b.String()
+}
+
+// IMAPEnvelope returns the RFC 3501 envelope structure for the provided message header.
+func IMAPEnvelope(header textproto.MIMEHeader) string {
+	b := new(bytes.Buffer)
+	writeEnvelope(b, header)
+	return b.String()
+}
+
+// writeBodyStructure recursively writes the body structure of p to b
+func writeBodyStructure(b *bytes.Buffer, p MIMEPart, extended bool) {
+	header := p.Header()
+	_, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	mtype, subtype := splitMediaType(p.ContentType())
+
+	b.WriteByte('(')
+	if mtype == "multipart" {
+		if p.FirstChild() == nil {
+			// A multipart must contain at least one body, describe an empty one
+			writeBodyStructure(b, NewMIMEPart(p, "text/plain"), extended)
+		}
+		for c := p.FirstChild(); c != nil; c = c.NextSibling() {
+			writeBodyStructure(b, c, extended)
+		}
+		b.WriteByte(' ')
+		b.WriteString(imapString(strings.ToUpper(subtype)))
+		if extended {
+			b.WriteByte(' ')
+			writeParams(b, params)
+			b.WriteByte(' ')
+			writePartExtension(b, header)
+		}
+		b.WriteByte(')')
+		return
+	}
+
+	encoding := header.Get("Content-Transfer-Encoding")
+	if encoding == "" {
+		encoding = "7bit"
+	}
+	size, lines := rawStats(p)
+
+	b.WriteString(imapString(strings.ToUpper(mtype)))
+	b.WriteByte(' ')
+	b.WriteString(imapString(strings.ToUpper(subtype)))
+	b.WriteByte(' ')
+	writeParams(b, params)
+	b.WriteByte(' ')
+	b.WriteString(imapNString(header.Get("Content-Id")))
+	b.WriteByte(' ')
+	b.WriteString(imapNString(header.Get("Content-Description")))
+	b.WriteByte(' ')
+	b.WriteString(imapString(strings.ToUpper(encoding)))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Itoa(size))
+
+	switch {
+	case mtype == "text":
+		b.WriteByte(' ')
+		b.WriteString(strconv.Itoa(lines))
+	case mtype == "message" && subtype == "rfc822":
+		inner := parseEmbeddedMessage(p.Content())
+		b.WriteByte(' ')
+		writeEnvelope(b, inner.Header())
+		b.WriteByte(' ')
+		writeBodyStructure(b, inner, extended)
+		b.WriteByte(' ')
+		b.WriteString(strconv.Itoa(lines))
+	}
+
+	if extended {
+		b.WriteByte(' ')
+		b.WriteString(imapNString(header.Get("Content-Md5")))
+		b.WriteByte(' ')
+		writePartExtension(b, header)
+	}
+	b.WriteByte(')')
+}
+
+// writePartExtension writes the disposition, language and location extension fields
+// shared by multipart and single part bodies
+func writePartExtension(b *bytes.Buffer, header textproto.MIMEHeader) {
+	// Disposition
+	disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		b.WriteString("NIL")
+	} else {
+		b.WriteByte('(')
+		b.WriteString(imapString(strings.ToUpper(disposition)))
+		b.WriteByte(' ')
+		writeParams(b, dparams)
+		b.WriteByte(')')
+	}
+
+	// Language
+	b.WriteByte(' ')
+	var langs []string
+	for _, l := range strings.Split(header.Get("Content-Language"), ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			langs = append(langs, l)
+		}
+	}
+	switch len(langs) {
+	case 0:
+		b.WriteString("NIL")
+	case 1:
+		b.WriteString(imapString(langs[0]))
+	default:
+		writeList(b, langs)
+	}
+
+	// Location
+	b.WriteByte(' ')
+	b.WriteString(imapNString(header.Get("Content-Location")))
+}
+
+// writeEnvelope writes the envelope structure for header to b
+func writeEnvelope(b *bytes.Buffer, header textproto.MIMEHeader) {
+	from := header.Get("From")
+	sender := header.Get("Sender")
+	if sender == "" {
+		sender = from
+	}
+	replyTo := header.Get("Reply-To")
+	if replyTo == "" {
+		replyTo = from
+	}
+
+	b.WriteByte('(')
+	b.WriteString(imapNString(header.Get("Date")))
+	b.WriteByte(' ')
+	b.WriteString(imapNString(header.Get("Subject")))
+	for _, addrs := range []string{from, sender, replyTo, header.Get("To"), header.Get("Cc"),
+		header.Get("Bcc")} {
+		b.WriteByte(' ')
+		writeAddressList(b, addrs)
+	}
+	b.WriteByte(' ')
+	b.WriteString(imapNString(header.Get("In-Reply-To")))
+	b.WriteByte(' ')
+	b.WriteString(imapNString(header.Get("Message-Id")))
+	b.WriteByte(')')
+}
+
+// writeAddressList writes the list of addresses in value as IMAP address structures, or NIL
+// if there are none or they could not be parsed
+func writeAddressList(b *bytes.Buffer, value string) {
+	addrs, err := mail.ParseAddressList(value)
+	if err != nil || len(addrs) == 0 {
+		b.WriteString("NIL")
+		return
+	}
+	b.WriteByte('(')
+	for _, a := range addrs {
+		mailbox, host := a.Address, ""
+		if i := strings.LastIndex(a.Address, "@"); i >= 0 {
+			mailbox, host = a.Address[:i], a.Address[i+1:]
+		}
+		b.WriteByte('(')
+		b.WriteString(imapNString(mime.QEncoding.Encode("utf-8", a.Name)))
+		b.WriteString(" NIL ")
+		b.WriteString(imapNString(mailbox))
+		b.WriteByte(' ')
+		b.WriteString(imapNString(host))
+		b.WriteByte(')')
+	}
+	b.WriteByte(')')
+}
+
+// writeParams writes a parenthesized list of attribute/value pairs sorted by attribute, or
+// NIL if there are none
+func writeParams(b *bytes.Buffer, params map[string]string) {
+	if len(params) == 0 {
+		b.WriteString("NIL")
+		return
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		list = append(list, strings.ToUpper(k), params[k])
+	}
+	writeList(b, list)
+}
+
+// writeList writes a parenthesized list of strings
+func writeList(b *bytes.Buffer, list []string) {
+	b.WriteByte('(')
+	for i, s := range list {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(imapString(s))
+	}
+	b.WriteByte(')')
+}
+
+// imapString formats s as an IMAP quoted string, or as a literal if it contains characters
+// that may not appear in a quoted string
+func imapString(s string) string {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == 0, c == '\r', c == '\n', c > 127:
+			return "{" + strconv.Itoa(len(s)) + "}\r\n" + s
+		}
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// imapNString formats s as an IMAP string, or NIL if it is empty
+func imapNString(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	return imapString(s)
+}
+
+// splitMediaType splits a media type into its lower case type and subtype
+func splitMediaType(mediatype string) (mtype, subtype string) {
+	mediatype = strings.ToLower(mediatype)
+	if i := strings.Index(mediatype, "/"); i >= 0 {
+		return mediatype[:i], mediatype[i+1:]
+	}
+	return mediatype, ""
+}
+
+// rawStats returns the size and line count of the undecoded content of p.  Parts not
+// created by this package are measured by their decoded content.
+func rawStats(p MIMEPart) (size, lines int) {
+	if mp, ok := p.(*memMIMEPart); ok && (mp.rawSize > 0 || mp.content == nil) {
+		return mp.rawSize, mp.rawLines
+	}
+	content := p.Content()
+	return len(content), bytes.Count(content, []byte{'\n'})
+}
+
+// parseEmbeddedMessage parses the content of a message/rfc822 part into a MIMEPart tree.  If
+// the message is malformed, an empty text/plain part is returned in its place.
+func parseEmbeddedMessage(content []byte) MIMEPart {
+	root, err := ParseMIME(bufio.NewReader(bytes.NewReader(content)))
+	if err != nil {
+		return NewMIMEPart(nil, "text/plain")
+	}
+	return root
+}
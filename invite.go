@@ -0,0 +1,143 @@
+package enmime
+
+import (
+	"bytes"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Where a calendar invite was found, see Invite.Source.
+const (
+	InviteTextCalendar = "text/calendar"   // A text/calendar part, as RFC 6047 specifies
+	InviteApplication  = "application/ics" // An application/ics part
+	InviteAttachment   = "attachment"      // A part named *.ics with another content type
+	InviteTNEF         = "tnef"            // An application/ms-tnef meeting message
+)
+
+// Invite is a meeting invitation, reply or cancellation found in a message.
+type Invite struct {
+	Data   []byte   // The iCalendar object with CRLF line endings and no byte order mark
+	Method string   // The upper case iTIP method, such as REQUEST, REPLY or CANCEL
+	Source string   // Where it was found, such as InviteTextCalendar
+	Part   MIMEPart // The part it was found in, the TNEF part for InviteTNEF
+
+	// For InviteTNEF, the MAPI message class and the raw values of the message properties
+	// keyed by property ID.  Data is nil if the TNEF part carries no iCalendar attachment, in
+	// which case the meeting is only described by Properties.
+	Class      string
+	Properties map[uint16][]byte
+}
+
+// Invite finds the calendar invite of the message, or returns nil if it has none.  Outlook
+// and other clients place it differently, see FindInvite.
+func (m *MIMEBody) Invite() *Invite {
+	if m.Root != nil {
+		return FindInvite(m.Root)
+	}
+	// A text only message may be the calendar object itself
+	mediatype, params, _ := mime.ParseMediaType(m.header.Get("Content-Type"))
+	if mediatype != "text/calendar" {
+		return nil
+	}
+	return newInvite([]byte(m.Text), params["method"], InviteTextCalendar, nil)
+}
+
+// FindInvite searches the tree beneath root for a calendar invite, returning the first of: a
+// text/calendar part, an application/ics part, a part with an .ics file name, or a TNEF part
+// with an iCalendar attachment or an IPM.Schedule.Meeting message class.  It returns nil if
+// none is found.
+func FindInvite(root MIMEPart) *Invite {
+	if p := BreadthMatchFirst(root, func(p MIMEPart) bool {
+		return p.ContentType() == "text/calendar"
+	}); p != nil {
+		_, params, _ := mime.ParseMediaType(p.Header().Get("Content-Type"))
+		return newInvite(p.Content(), params["method"], InviteTextCalendar, p)
+	}
+	if p := BreadthMatchFirst(root, func(p MIMEPart) bool {
+		return p.ContentType() == "application/ics"
+	}); p != nil {
+		return newInvite(p.Content(), "", InviteApplication, p)
+	}
+	if p := BreadthMatchFirst(root, func(p MIMEPart) bool {
+		return strings.EqualFold(filepath.Ext(p.FileName()), ".ics")
+	}); p != nil {
+		return newInvite(p.Content(), "", InviteAttachment, p)
+	}
+
+	tnefParts := BreadthMatchAll(root, func(p MIMEPart) bool {
+		return p.FirstChild() == nil && IsTNEF(p.Content())
+	})
+	for _, p := range tnefParts {
+		msg, err := parseTNEF(p.Content())
+		if err != nil {
+			continue
+		}
+		for _, a := range msg.attachments {
+			if a.mimeType == "text/calendar" || a.mimeType == "application/ics" ||
+				strings.EqualFold(filepath.Ext(a.name), ".ics") {
+				return tnefInvite(msg, a.data, p)
+			}
+		}
+		if strings.HasPrefix(strings.ToLower(msg.class), "ipm.schedule.meeting.") {
+			// Outlook sent the meeting as MAPI properties only
+			return tnefInvite(msg, nil, p)
+		}
+	}
+	return nil
+}
+
+// tnefInvite returns an Invite for the TNEF message found in p, with data its iCalendar
+// attachment or nil if it has none
+func tnefInvite(msg *tnefMessage, data []byte, p MIMEPart) *Invite {
+	inv := &Invite{Source: InviteTNEF, Part: p}
+	if data != nil {
+		inv = newInvite(data, "", InviteTNEF, p)
+	}
+	if inv.Method == "" {
+		inv.Method = tnefMethod(msg.class)
+	}
+	inv.Class = msg.class
+	inv.Properties = msg.props
+	return inv
+}
+
+// tnefMethod returns the iTIP method matching a meeting message class, or ""
+func tnefMethod(class string) string {
+	class = strings.ToLower(class)
+	switch {
+	case strings.HasPrefix(class, "ipm.schedule.meeting.request"):
+		return "REQUEST"
+	case strings.HasPrefix(class, "ipm.schedule.meeting.canceled"):
+		return "CANCEL"
+	case strings.HasPrefix(class, "ipm.schedule.meeting.resp."):
+		return "REPLY"
+	}
+	return ""
+}
+
+// newInvite returns an Invite for the iCalendar data, taking the method from the data if the
+// Content-Type did not give one
+func newInvite(data []byte, method, source string, p MIMEPart) *Invite {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimLeft(data, " \t\r\n")
+	data = normalizeLineEndings(data, LineEndingCRLF)
+	if method == "" {
+		method = calendarMethod(data)
+	}
+	return &Invite{Data: data, Method: strings.ToUpper(method), Source: source, Part: p}
+}
+
+// calendarMethod returns the METHOD property of an iCalendar object, or ""
+func calendarMethod(data []byte) string {
+	for _, line := range bytes.Split(data, []byte("\r\n")) {
+		if bytes.HasPrefix(bytes.ToUpper(line), []byte("METHOD:")) {
+			return strings.TrimSpace(string(line[len("METHOD:"):]))
+		}
+		if bytes.HasPrefix(bytes.ToUpper(line), []byte("BEGIN:VEVENT")) {
+			// METHOD belongs to the calendar, before its components
+			break
+		}
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package enmime
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// iocContext is how many bytes of text either side of a URL are kept as its context
+const iocContext = 40
+
+// Indicators are the URLs and attachments of a message, the indicators of compromise threat
+// intelligence and phishing detection pipelines look up.
+type Indicators struct {
+	URLs        []URLIndicator        // Distinct URLs, in order of first appearance
+	Attachments []AttachmentIndicator // Attachments and inline parts, excluding the bodies
+}
+
+// URLIndicator is a URL found in the text or HTML body.
+type URLIndicator struct {
+	URL     string   // The URL, with HTML character references decoded
+	Context string   // Text around the first occurrence, white space collapsed
+	Text    string   // Text of the first HTML link to the URL, if any; phishing links often differ
+	Sources []string // Where the URL occurred: "text", "html" or both
+	Count   int      // Number of occurrences
+}
+
+// AttachmentIndicator identifies the content of an attachment or inline part.
+type AttachmentIndicator struct {
+	PartID      string
+	FileName    string
+	ContentType string // Content type as declared
+	Sniffed     string // Content type detected from the content, see http.DetectContentType
+	Size        int    // Decoded size in bytes
+	MD5         string // Hex encoded digests of the decoded content
+	SHA1        string
+	SHA256      string
+}
+
+var (
+	bareURLRE  = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s<>"']+|\bwww\.[a-z0-9-]+\.[^\s<>"']+`)
+	htmlLinkRE = regexp.MustCompile(`(?is)<a\b[^>]*>(.*?)</a\s*>`)
+	htmlTextRE = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// Indicators extracts the URLs and attachment digests of the message in one pass.
+func (m *MIMEBody) Indicators() *Indicators {
+	ind := &Indicators{}
+	seen := make(map[string]*URLIndicator)
+	var order []string
+	add := func(u, source, context, text string) {
+		u = strings.TrimRight(u, ".,;:!?)]}'\"")
+		if u == "" {
+			return
+		}
+		ui := seen[u]
+		if ui == nil {
+			ui = &URLIndicator{URL: u, Context: context, Text: text}
+			seen[u] = ui
+			order = append(order, u)
+		}
+		if ui.Text == "" {
+			ui.Text = text
+		}
+		if !containsString(ui.Sources, source) {
+			ui.Sources = append(ui.Sources, source)
+		}
+		ui.Count++
+	}
+
+	for _, loc := range bareURLRE.FindAllStringIndex(m.Text, -1) {
+		add(m.Text[loc[0]:loc[1]], "text", iocSnippet(m.Text, loc[0], loc[1]), "")
+	}
+
+	if m.Html != "" {
+		// Link targets first, so each URL gets the text of its link
+		linkText := make(map[int]string)
+		for _, l := range htmlLinkRE.FindAllStringSubmatchIndex(m.Html, -1) {
+			text := html.UnescapeString(htmlTextRE.ReplaceAllString(m.Html[l[2]:l[3]], " "))
+			linkText[l[0]] = strings.Join(strings.Fields(text), " ")
+		}
+		for _, t := range htmlTagRE.FindAllStringSubmatchIndex(m.Html, -1) {
+			attrs := m.Html[t[4]:t[5]]
+			for _, a := range htmlAttrRE.FindAllStringSubmatch(attrs, -1) {
+				switch strings.ToLower(a[1]) {
+				case "href", "src", "action", "background", "poster", "data":
+				default:
+					continue
+				}
+				u := html.UnescapeString(strings.TrimSpace(strings.Trim(a[2], `"'`)))
+				if isRemoteURL(u) || strings.HasPrefix(strings.ToLower(u), "ftp://") {
+					add(u, "html", iocSnippet(m.Html, t[0], t[1]), linkText[t[0]])
+				}
+			}
+		}
+		// Then URLs in the text or style of the document
+		for _, ref := range RemoteContent(m.Html) {
+			if ref.Attr == "url" {
+				add(ref.URL, "html", iocSnippet(m.Html, ref.Start, ref.End), "")
+			}
+		}
+		text := html.UnescapeString(htmlTextRE.ReplaceAllString(m.Html, " "))
+		for _, loc := range bareURLRE.FindAllStringIndex(text, -1) {
+			add(text[loc[0]:loc[1]], "html", iocSnippet(text, loc[0], loc[1]), "")
+		}
+	}
+	for _, u := range order {
+		ind.URLs = append(ind.URLs, *seen[u])
+	}
+
+	parts := append(append([]MIMEPart{}, m.Attachments...), m.Inlines...)
+	for _, p := range parts {
+		content := p.Content()
+		md5Sum := md5.Sum(content)
+		sha1Sum := sha1.Sum(content)
+		sha256Sum := sha256.Sum256(content)
+		ind.Attachments = append(ind.Attachments, AttachmentIndicator{
+			PartID:      p.PartID(),
+			FileName:    p.FileName(),
+			ContentType: p.ContentType(),
+			Sniffed:     http.DetectContentType(content),
+			Size:        len(content),
+			MD5:         hex.EncodeToString(md5Sum[:]),
+			SHA1:        hex.EncodeToString(sha1Sum[:]),
+			SHA256:      hex.EncodeToString(sha256Sum[:]),
+		})
+	}
+	return ind
+}
+
+// iocSnippet returns the text around s[start:end], white space collapsed and cut at rune
+// boundaries
+func iocSnippet(s string, start, end int) string {
+	from, to := start-iocContext, end+iocContext
+	if from < 0 {
+		from = 0
+	}
+	if to > len(s) {
+		to = len(s)
+	}
+	for from > 0 && !utf8.RuneStart(s[from]) {
+		from--
+	}
+	for to < len(s) && !utf8.RuneStart(s[to]) {
+		to++
+	}
+	return strings.Join(strings.Fields(s[from:to]), " ")
+}
+
+// containsString returns true if list contains s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package enmime
+
+import (
+	"bytes"
+)
+
+// LineEnding selects the line breaks that decoded text parts are normalized to.
+type LineEnding int
+
+const (
+	// LineEndingAsIs leaves line breaks as they were decoded
+	LineEndingAsIs LineEnding = iota
+	// LineEndingLF converts CRLF and lone CR line breaks to LF
+	LineEndingLF
+	// LineEndingCRLF converts LF and lone CR line breaks to CRLF
+	LineEndingCRLF
+)
+
+// normalizeLineEndings returns a copy of b with every CRLF, CR and LF line break converted to
+// the selected ending, or b itself for LineEndingAsIs
+func normalizeLineEndings(b []byte, ending LineEnding) []byte {
+	var eol []byte
+	switch ending {
+	case LineEndingLF:
+		eol = []byte("\n")
+	case LineEndingCRLF:
+		eol = []byte("\r\n")
+	default:
+		return b
+	}
+
+	out := make([]byte, 0, len(b)+len(b)/32)
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\r':
+			if i+1 < len(b) && b[i+1] == '\n' {
+				i++
+			}
+			out = append(out, eol...)
+		case '\n':
+			out = append(out, eol...)
+		default:
+			out = append(out, b[i])
+		}
+	}
+	return out
+}
+
+// conformsLineEndings returns true if every line break in b is already the selected ending
+func conformsLineEndings(b []byte, ending LineEnding) bool {
+	switch ending {
+	case LineEndingAsIs:
+		return true
+	case LineEndingLF:
+		return bytes.IndexByte(b, '\r') < 0
+	}
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\r':
+			if i+1 >= len(b) || b[i+1] != '\n' {
+				return false
+			}
+			i++
+		case '\n':
+			return false
+		}
+	}
+	return true
+}
@@ -1,20 +1,29 @@
 package enmime
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"mime"
 	"net/mail"
+	"net/textproto"
+	"sort"
 	"strings"
+	"time"
 )
 
 // MIMEBody is the outer wrapper for MIME messages.
 type MIMEBody struct {
-	Text        string      // The plain text portion of the message
-	Html        string      // The HTML portion of the message
-	Root        MIMEPart    // The top-level MIMEPart
-	Attachments []MIMEPart  // All parts having a Content-Disposition of attachment
-	Inlines     []MIMEPart  // All parts having a Content-Disposition of inline
-	header      mail.Header // Header from original message
+	Text        string       // The plain text portion of the message
+	Html        string       // The HTML portion of the message
+	Root        MIMEPart     // The top-level MIMEPart
+	Attachments []MIMEPart   // All parts having a Content-Disposition of attachment
+	Inlines     []MIMEPart   // All parts having a Content-Disposition of inline
+	Warnings    []Warning    // Anomalies the parser worked around
+	Scans       []ScanResult // Scanner results for the body of a text only message
+	header      mail.Header  // Header from original message
+	rawBody     []byte       // Undecoded body, if ParseOptions.KeepRawBody was set
+	textOffsets *OffsetMap   // Offsets of Text, if ParseOptions.MapOffsets was set
 }
 
 // IsMultipartMessage returns true if the message has a recognized multipart Content-Type
@@ -43,23 +52,63 @@ func IsMultipartMessage(mailMsg *mail.Message) bool {
 // encoded in quoted-printable or base64, it is decoded before being stored in the
 // MIMEPart object.
 func ParseMIMEBody(mailMsg *mail.Message) (*MIMEBody, error) {
+	return ParseMIMEBodyWithOptions(mailMsg, nil)
+}
+
+// ParseMIMEBodyWithOptions is like ParseMIMEBody, with optional behavior controlled by opts.
+func ParseMIMEBodyWithOptions(mailMsg *mail.Message, opts *ParseOptions) (body *MIMEBody, err error) {
+	pr := newParser(opts)
+	start := time.Now()
+	defer func() { pr.parseTimer(start, err) }()
+	defer pr.recoverPanic(&err)
 	mimeMsg := &MIMEBody{header: mailMsg.Header}
+	input := mailMsg.Body
+	var raw *bytes.Buffer
+	if pr.opts.KeepRawBody {
+		raw = &bytes.Buffer{}
+		input = io.TeeReader(input, raw)
+	}
 
 	if !IsMultipartMessage(mailMsg) {
 		// Parse as text only
-		bodyBytes, err := decodeSection(mailMsg.Header.Get("Content-Transfer-Encoding"), mailMsg.Header.Get("charset"),
-			mailMsg.Body)
+		header := textproto.MIMEHeader(mailMsg.Header)
+		var bodyBytes []byte
+		var offsets *OffsetMap
+		if pr.opts.MapOffsets && header.Get("Content-Encoding") == "" {
+			var raw []byte
+			if raw, err = io.ReadAll(input); err == nil {
+				bodyBytes, offsets, err = pr.mapContent(header, raw)
+			}
+		} else {
+			bodyBytes, err = pr.decodeContent(header, input)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("Error decoding text-only message: %v", err)
 		}
-		mimeMsg.Text = string(bodyBytes)
-
 		// Check for HTML at top-level, eat errors quietly
 		ctype := mailMsg.Header.Get("Content-Type")
+		if mediatype, _, _ := mime.ParseMediaType(ctype); ctype == "" ||
+			strings.HasPrefix(mediatype, "text/") {
+			if !conformsLineEndings(bodyBytes, pr.opts.LineEndings) {
+				offsets = nil
+			}
+			bodyBytes = normalizeLineEndings(bodyBytes, pr.opts.LineEndings)
+		}
+		if err := pr.scanText(mimeMsg, header, bodyBytes); err != nil {
+			return nil, err
+		}
+		mimeMsg.textOffsets = offsets
+		mimeMsg.Text = string(bodyBytes)
 		if ctype != "" {
 			if mediatype, _, err := mime.ParseMediaType(ctype); err == nil {
-				if mediatype == "text/html" {
+				switch mediatype {
+				case "text/html":
 					mimeMsg.Html = mimeMsg.Text
+				case "text/enriched", "text/richtext":
+					mimeMsg.textOffsets = nil
+					enriched := mimeMsg.Text
+					mimeMsg.Text = convertEnriched(mediatype, enriched, false)
+					mimeMsg.Html = convertEnriched(mediatype, enriched, true)
 				}
 			}
 		}
@@ -80,8 +129,9 @@ func ParseMIMEBody(mailMsg *mail.Message) (*MIMEBody, error) {
 
 		// Root Node of our tree
 		root := NewMIMEPart(nil, mediatype)
+		root.header = textproto.MIMEHeader(mailMsg.Header)
 		mimeMsg.Root = root
-		err = parseParts(root, mailMsg.Body, boundary)
+		err = pr.parseParts(root, input, boundary)
 		if err != nil {
 			return nil, err
 		}
@@ -93,6 +143,7 @@ func ParseMIMEBody(mailMsg *mail.Message) (*MIMEBody, error) {
 			})
 			if match != nil {
 				mimeMsg.Text = string(match.Content())
+				mimeMsg.textOffsets = partTextOffsets(match)
 			}
 		} else {
 			// multipart is of a mixed type
@@ -105,6 +156,9 @@ func ParseMIMEBody(mailMsg *mail.Message) (*MIMEBody, error) {
 				}
 				mimeMsg.Text += string(m.Content())
 			}
+			if len(match) == 1 {
+				mimeMsg.textOffsets = partTextOffsets(match[0])
+			}
 		}
 
 		// Locate HTML body
@@ -115,17 +169,54 @@ func ParseMIMEBody(mailMsg *mail.Message) (*MIMEBody, error) {
 			mimeMsg.Html = string(match.Content())
 		}
 
+		// Fall back to an enriched body when there is no plain text or HTML one
+		if mimeMsg.Text == "" || mimeMsg.Html == "" {
+			match := BreadthMatchFirst(root, func(p MIMEPart) bool {
+				return (p.ContentType() == "text/enriched" || p.ContentType() == "text/richtext") &&
+					p.Disposition() != "attachment"
+			})
+			if match != nil {
+				if mimeMsg.Text == "" {
+					mimeMsg.Text = convertEnriched(match.ContentType(), string(match.Content()), false)
+				}
+				if mimeMsg.Html == "" {
+					mimeMsg.Html = convertEnriched(match.ContentType(), string(match.Content()), true)
+				}
+			}
+		}
+
 		// Locate attachments
 		mimeMsg.Attachments = BreadthMatchAll(root, func(p MIMEPart) bool {
-			return p.Disposition() == "attachment"
+			return p.Disposition() == "attachment" && !IsAppleResourceFork(p)
 		})
 
 		// Locate inlines
 		mimeMsg.Inlines = BreadthMatchAll(root, func(p MIMEPart) bool {
-			return p.Disposition() == "inline"
+			return p.Disposition() == "inline" && !IsAppleResourceFork(p)
 		})
 	}
 
+	if pr.opts.ExtractEncodedBlocks {
+		text, parts := ExtractEncodedBlocks(mimeMsg.Text)
+		if text != mimeMsg.Text {
+			mimeMsg.textOffsets = nil
+		}
+		mimeMsg.Text = text
+		mimeMsg.Attachments = append(mimeMsg.Attachments, parts...)
+	}
+	mimeMsg.Warnings = pr.warnings
+	if raw != nil {
+		// The multipart reader stops at the closing boundary, keep the epilogue too
+		if _, err := io.Copy(io.Discard, input); err != nil {
+			return nil, fmt.Errorf("Error reading message body: %v", err)
+		}
+		mimeMsg.rawBody = raw.Bytes()
+		if mimeMsg.rawBody == nil {
+			// An empty body was still kept
+			mimeMsg.rawBody = []byte{}
+		}
+	}
+
 	return mimeMsg, nil
 }
 
@@ -133,3 +224,35 @@ func ParseMIMEBody(mailMsg *mail.Message) (*MIMEBody, error) {
 func (m *MIMEBody) GetHeader(name string) string {
 	return decodeHeader(m.header.Get(name))
 }
+
+// GetHeaderValues decodes every occurrence of the specified header, such as Received, in the
+// order they appear.
+func (m *MIMEBody) GetHeaderValues(name string) []string {
+	values := m.header[textproto.CanonicalMIMEHeaderKey(name)]
+	if values == nil {
+		return nil
+	}
+	decoded := make([]string, len(values))
+	for i, v := range values {
+		decoded[i] = decodeHeader(v)
+	}
+	return decoded
+}
+
+// HeaderFields returns every header field with its decoded value, sorted by name with repeats
+// of a field in the order they appear.  The order between fields of different names is lost
+// by net/mail, ReadRawHeader keeps it.
+func (m *MIMEBody) HeaderFields() []HeaderField {
+	names := make([]string, 0, len(m.header))
+	for k := range m.header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var fields []HeaderField
+	for _, k := range names {
+		for _, v := range m.header[k] {
+			fields = append(fields, HeaderField{Name: k, Value: decodeHeader(v)})
+		}
+	}
+	return fields
+}
@@ -0,0 +1,107 @@
+package enmime
+
+import (
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaildirMessage describes a single message file found in a Maildir.
+type MaildirMessage struct {
+	Path   string // Full path of the message file
+	Subdir string // Maildir subdirectory the file was found in, "new" or "cur"
+	Key    string // Unique name of the message, without the info suffix
+	Flags  string // Flags from the ":2," info suffix, e.g. "RS"
+}
+
+// MaildirWalkFunc is the type of function called by WalkMaildir for each message.  err is
+// non-nil if the message could not be parsed, in which case body is nil.  Returning an error
+// stops the walk.
+type MaildirWalkFunc func(msg *MaildirMessage, body *MIMEBody, err error) error
+
+// WalkMaildir parses every message in the new and cur subdirectories of the Maildir at dir,
+// calling fn for each one in turn.  Files whose names begin with a dot are skipped.
+func WalkMaildir(dir string, fn MaildirWalkFunc) error {
+	for _, subdir := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, subdir))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			msg := NewMaildirMessage(filepath.Join(dir, subdir, e.Name()))
+			body, err := msg.Parse()
+			if err = fn(msg, body, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NewMaildirMessage returns a MaildirMessage for the file at path, with its key and flags
+// taken from the file name.
+func NewMaildirMessage(path string) *MaildirMessage {
+	msg := &MaildirMessage{Path: path, Subdir: filepath.Base(filepath.Dir(path))}
+	name := filepath.Base(path)
+	msg.Key = name
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		msg.Key = name[:i]
+		if info := name[i+1:]; strings.HasPrefix(info, "2,") {
+			msg.Flags = info[2:]
+		}
+	}
+	return msg
+}
+
+// Parse reads the message file and parses it into a MIMEBody
+func (m *MaildirMessage) Parse() (*MIMEBody, error) {
+	f, err := os.Open(m.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mailMsg, err := mail.ReadMessage(f)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMIMEBody(mailMsg)
+}
+
+// HasFlag returns true if the flag character is present in the message flags
+func (m *MaildirMessage) HasFlag(flag rune) bool {
+	return strings.ContainsRune(m.Flags, flag)
+}
+
+// Passed returns true if the message has been resent, forwarded or bounced (flag P)
+func (m *MaildirMessage) Passed() bool {
+	return m.HasFlag('P')
+}
+
+// Replied returns true if the message has been replied to (flag R)
+func (m *MaildirMessage) Replied() bool {
+	return m.HasFlag('R')
+}
+
+// Seen returns true if the message has been viewed (flag S)
+func (m *MaildirMessage) Seen() bool {
+	return m.HasFlag('S')
+}
+
+// Trashed returns true if the message has been marked for deletion (flag T)
+func (m *MaildirMessage) Trashed() bool {
+	return m.HasFlag('T')
+}
+
+// Draft returns true if the message is a draft (flag D)
+func (m *MaildirMessage) Draft() bool {
+	return m.HasFlag('D')
+}
+
+// Flagged returns true if the message has been flagged for urgency (flag F)
+func (m *MaildirMessage) Flagged() bool {
+	return m.HasFlag('F')
+}
@@ -0,0 +1,187 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MboxFormat selects how From_ lines inside of messages were escaped when the mbox file was
+// written.
+type MboxFormat int
+
+const (
+	// MboxO files escape "From " at the start of a line as ">From ", lines already starting
+	// with ">From " are left alone, so unescaping is ambiguous.
+	MboxO MboxFormat = iota
+	// MboxRD files escape any line matching ">*From " by prepending another '>'
+	MboxRD
+)
+
+// MboxReader iterates over the messages in an mbox file.  Messages are streamed from the
+// underlying reader one line at a time, so memory use does not grow with the size of the
+// file.
+type MboxReader struct {
+	r         *bufio.Reader
+	format    MboxFormat
+	fromLine  string // From_ line of the current message
+	msg       *mboxMessage
+	buf       []byte // Unescaped data ready to be read from the current message
+	held      []byte // Blank line held back in case it precedes the next From_ line
+	lineStart bool   // True if the next byte read starts a line
+	end       bool   // True when the current message has been fully read
+	err       error  // Sticky error from the underlying reader
+}
+
+// mboxMessage is the io.Reader for a single message returned by MboxReader.Next
+type mboxMessage struct {
+	m *MboxReader
+}
+
+// NewMboxReader returns an MboxReader reading from r, which is expected to be in the
+// specified format.
+func NewMboxReader(r io.Reader, format MboxFormat) *MboxReader {
+	return &MboxReader{r: bufio.NewReader(r), format: format, lineStart: true, end: true}
+}
+
+// Next advances to the next message in the mbox, discarding any unread portion of the
+// current one, and returns a reader for its content with From_ escaping removed.  The
+// reader is only valid until the next call to Next.  Next returns io.EOF when there are no
+// more messages.
+func (m *MboxReader) Next() (io.Reader, error) {
+	if m.msg != nil {
+		if _, err := io.Copy(io.Discard, m.msg); err != nil {
+			return nil, err
+		}
+		m.msg = nil
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	// Skip blank lines, then we expect a From_ line
+	for {
+		line, err := m.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("Expected From_ line, got %q", line)
+		}
+		if len(line) == 0 && err != nil {
+			m.err = err
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				m.err = err
+				return nil, err
+			}
+			continue
+		}
+		if !bytes.HasPrefix(line, []byte("From ")) {
+			m.err = fmt.Errorf("Expected From_ line, got %q", line)
+			return nil, m.err
+		}
+		m.fromLine = string(bytes.TrimRight(line, "\r\n"))
+		if err != nil {
+			// From_ line at the end of the file, an empty message follows
+			m.err = err
+		}
+		break
+	}
+
+	m.buf, m.held = m.buf[:0], nil
+	m.lineStart = true
+	m.end = m.err != nil
+	m.msg = &mboxMessage{m: m}
+	return m.msg, nil
+}
+
+// NextMIME advances to the next message in the mbox and parses it with ParseMIME.  It
+// returns io.EOF when there are no more messages.  A parse error does not prevent reading
+// subsequent messages.
+func (m *MboxReader) NextMIME() (MIMEPart, error) {
+	r, err := m.Next()
+	if err != nil {
+		return nil, err
+	}
+	return ParseMIME(bufio.NewReader(r))
+}
+
+// FromLine returns the From_ line that introduced the current message, without the line
+// ending.
+func (m *MboxReader) FromLine() string {
+	return m.fromLine
+}
+
+// Read method for io.Reader interface.
+func (mr *mboxMessage) Read(p []byte) (n int, err error) {
+	m := mr.m
+	for len(m.buf) == 0 {
+		if m.end {
+			return 0, io.EOF
+		}
+		if err := m.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+// fill reads the next line of the current message into buf, stopping at the next From_ line
+// or the end of the input.
+func (m *MboxReader) fill() error {
+	if m.lineStart {
+		if b, _ := m.r.Peek(5); string(b) == "From " {
+			// Start of the next message, the held blank line was the separator
+			m.end = true
+			return nil
+		}
+	}
+
+	line, err := m.r.ReadSlice('\n')
+	if err != nil && err != bufio.ErrBufferFull {
+		if err != io.EOF {
+			return err
+		}
+		m.err = err
+		m.end = true
+	}
+
+	m.buf = m.buf[:0]
+	if m.lineStart {
+		line = m.unescape(line)
+		if err == nil && (string(line) == "\n" || string(line) == "\r\n") {
+			// Blank line, hold it back until we know if a From_ line follows
+			m.buf = append(m.buf, m.held...)
+			m.held = append(m.held[:0], line...)
+			return nil
+		}
+	}
+	m.lineStart = err == nil
+	if m.end && len(line) == 0 {
+		// A blank line held back at the end of the input was the final separator
+		m.held = m.held[:0]
+	}
+	m.buf = append(m.buf, m.held...)
+	m.buf = append(m.buf, line...)
+	m.held = m.held[:0]
+
+	return nil
+}
+
+// unescape removes the From_ quoting from the start of a line
+func (m *MboxReader) unescape(line []byte) []byte {
+	if !bytes.HasPrefix(line, []byte(">")) {
+		return line
+	}
+	quoted := line[1:]
+	if m.format == MboxRD {
+		quoted = bytes.TrimLeft(line, ">")
+	}
+	if bytes.HasPrefix(quoted, []byte("From ")) {
+		return line[1:]
+	}
+	return line
+}
@@ -0,0 +1,75 @@
+package enmime
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receives instrumentation events from the parser, set it in ParseOptions to feed
+// counters and timers of a monitoring system.  An implementation shared between concurrent
+// parses must be safe for concurrent use.
+type Metrics interface {
+	PartParsed(contentType string)        // A MIMEPart was added to the tree
+	BytesDecoded(encoding string, n int)  // n bytes were produced by a transfer encoding
+	CharsetConverted(charset string)      // Text was converted from charset to UTF-8
+	ParseCompleted(elapsed time.Duration) // A message was parsed successfully
+	ParseFailed(elapsed time.Duration)    // A message failed to parse
+}
+
+// nopMetrics discards all events, it is used when no Metrics are configured
+type nopMetrics struct{}
+
+func (nopMetrics) PartParsed(string)            {}
+func (nopMetrics) BytesDecoded(string, int)     {}
+func (nopMetrics) CharsetConverted(string)      {}
+func (nopMetrics) ParseCompleted(time.Duration) {}
+func (nopMetrics) ParseFailed(time.Duration)    {}
+
+// ExpvarMetrics is a Metrics implementation that maintains counters in an expvar.Map.
+type ExpvarMetrics struct {
+	m *expvar.Map
+}
+
+// NewExpvarMetrics returns Metrics that add to counters in m, such as one created with
+// expvar.NewMap.  Content types, encodings and charsets are counted under keys prefixed with
+// "parts.", "bytes.", and "charset." respectively; parse outcomes under "parses.ok" and
+// "parses.failed", and the total parse time under "parse_ns".
+func NewExpvarMetrics(m *expvar.Map) *ExpvarMetrics {
+	return &ExpvarMetrics{m: m}
+}
+
+// PartParsed implements Metrics
+func (e *ExpvarMetrics) PartParsed(contentType string) {
+	e.m.Add("parts."+contentType, 1)
+}
+
+// BytesDecoded implements Metrics
+func (e *ExpvarMetrics) BytesDecoded(encoding string, n int) {
+	e.m.Add("bytes."+encoding, int64(n))
+}
+
+// CharsetConverted implements Metrics
+func (e *ExpvarMetrics) CharsetConverted(charset string) {
+	e.m.Add("charset."+charset, 1)
+}
+
+// ParseCompleted implements Metrics
+func (e *ExpvarMetrics) ParseCompleted(elapsed time.Duration) {
+	e.m.Add("parses.ok", 1)
+	e.m.Add("parse_ns", int64(elapsed))
+}
+
+// ParseFailed implements Metrics
+func (e *ExpvarMetrics) ParseFailed(elapsed time.Duration) {
+	e.m.Add("parses.failed", 1)
+	e.m.Add("parse_ns", int64(elapsed))
+}
+
+// parseTimer reports the duration of a parse to the configured Metrics
+func (pr *parser) parseTimer(start time.Time, err error) {
+	if err != nil {
+		pr.metrics.ParseFailed(time.Since(start))
+	} else {
+		pr.metrics.ParseCompleted(time.Since(start))
+	}
+}
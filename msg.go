@@ -0,0 +1,469 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"code.google.com/p/mahonia"
+)
+
+// MAPI property identifiers used when mapping an Outlook .msg file onto a MIMEBody
+const (
+	msgPropSubject           = 0x0037
+	msgPropClientSubmitTime  = 0x0039
+	msgPropTransportHeaders  = 0x007D
+	msgPropSenderName        = 0x0C1A
+	msgPropSenderEmail       = 0x0C1F
+	msgPropRecipientType     = 0x0C15
+	msgPropDeliveryTime      = 0x0E06
+	msgPropBody              = 0x1000
+	msgPropBodyHTML          = 0x1013
+	msgPropInternetMessageID = 0x1035
+	msgPropDisplayName       = 0x3001
+	msgPropCreationTime      = 0x3007
+	msgPropLastModTime       = 0x3008
+	msgPropEmailAddress      = 0x3003
+	msgPropAttachData        = 0x3701
+	msgPropAttachFilename    = 0x3704
+	msgPropAttachMethod      = 0x3705
+	msgPropAttachLongName    = 0x3707
+	msgPropAttachMimeTag     = 0x370E
+	msgPropAttachContentID   = 0x3712
+	msgPropSMTPAddress       = 0x39FE
+	msgPropInternetCPID      = 0x3FDE
+	msgPropMessageCodepage   = 0x3FFD
+	msgPropSenderSMTPAddress = 0x5D01
+
+	msgTypeLong    = 0x0003
+	msgTypeBoolean = 0x000B
+	msgTypeObject  = 0x000D
+	msgTypeInt64   = 0x0014
+	msgTypeString8 = 0x001E
+	msgTypeUnicode = 0x001F
+	msgTypeSysTime = 0x0040
+	msgTypeBinary  = 0x0102
+
+	msgAttachEmbedded = 5 // Attach method for an embedded message
+
+	msgMaxDepth = 32 // Limit on nested storages, two for each embedded message
+)
+
+// msgCodepages maps Windows code page numbers to charset names known to mahonia
+var msgCodepages = map[int]string{
+	437:   "ibm437",
+	874:   "windows-874",
+	932:   "shift_jis",
+	936:   "gbk",
+	949:   "euc-kr",
+	950:   "big5",
+	1250:  "windows-1250",
+	1251:  "windows-1251",
+	1252:  "windows-1252",
+	1253:  "windows-1253",
+	1254:  "windows-1254",
+	1255:  "windows-1255",
+	1256:  "windows-1256",
+	1257:  "windows-1257",
+	1258:  "windows-1258",
+	20127: "us-ascii",
+	20866: "koi8-r",
+	28591: "iso-8859-1",
+	28592: "iso-8859-2",
+	28605: "iso-8859-15",
+	50220: "iso-2022-jp",
+	51932: "euc-jp",
+	54936: "gb18030",
+	65001: "utf-8",
+}
+
+// msgProp is a single MAPI property value
+type msgProp struct {
+	typ     uint16
+	data    []byte // Variable length value, or the 8 byte fixed value
+	storage int    // Directory entry of a PT_OBJECT value
+}
+
+// msgObject is a message, recipient or attachment storage within a .msg file
+type msgObject struct {
+	f        *cfbFile
+	props    map[uint16]msgProp
+	recips   []*msgObject
+	attachs  []*msgObject
+	codepage string
+	depth    int          // Nesting of the storage in the file
+	seen     map[int]bool // Storages read so far, shared by all objects of the file
+}
+
+// IsOutlookMsg returns true if data begins with the compound file signature used by Outlook
+// .msg files.
+func IsOutlookMsg(data []byte) bool {
+	return isCFB(data)
+}
+
+// ParseOutlookMsg reads an Outlook .msg file from r and maps its properties, recipients and
+// attachments onto a MIMEBody.  The header is taken from the original transport headers
+// when Outlook preserved them, otherwise it is built from the message properties.  Embedded
+// message attachments become message/rfc822 parts.
+func ParseOutlookMsg(r io.Reader) (*MIMEBody, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openCFB(data)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := readMsgObject(f, make(map[int]bool), 0, 32, 0)
+	if err != nil {
+		return nil, err
+	}
+	body, err := msg.toMIMEBody(0)
+	if err != nil {
+		return nil, err
+	}
+	if root, ok := body.Root.(*memMIMEPart); ok {
+		assignPartIDs(root, "")
+	}
+	return body, nil
+}
+
+// readMsgObject loads the properties and sub-objects of the storage at entry.  hdrSize is the
+// length of the header preceding the entries in its property stream.  seen records the
+// storages already read, so a file whose directory links a storage beneath itself fails
+// rather than recursing forever.
+func readMsgObject(f *cfbFile, seen map[int]bool, entry, hdrSize, depth int) (*msgObject,
+	error) {
+	if depth > msgMaxDepth {
+		return nil, fmt.Errorf("Outlook message nested too deeply")
+	}
+	if seen[entry] {
+		return nil, fmt.Errorf("Outlook message storage %v is linked more than once", entry)
+	}
+	seen[entry] = true
+	obj := &msgObject{f: f, props: make(map[uint16]msgProp), depth: depth, seen: seen}
+	for _, i := range f.children(entry) {
+		e := &f.entries[i]
+		switch {
+		case strings.HasPrefix(e.name, "__substg1.0_") && len(e.name) == 20:
+			tag, err := strconv.ParseUint(e.name[12:], 16, 32)
+			if err != nil {
+				continue
+			}
+			p := msgProp{typ: uint16(tag), storage: i}
+			if e.typ == cfbTypeStream {
+				if p.data, err = f.stream(e); err != nil {
+					return nil, err
+				}
+			}
+			obj.props[uint16(tag>>16)] = p
+		case e.name == "__properties_version1.0":
+			data, err := f.stream(e)
+			if err != nil {
+				return nil, err
+			}
+			for off := hdrSize; off+16 <= len(data); off += 16 {
+				tag := binary.LittleEndian.Uint32(data[off:])
+				switch typ := uint16(tag); typ {
+				case msgTypeLong, msgTypeBoolean, msgTypeInt64, msgTypeSysTime:
+					obj.props[uint16(tag>>16)] = msgProp{typ: typ, data: data[off+8 : off+16]}
+				}
+			}
+		case strings.HasPrefix(e.name, "__recip_version1.0_") && e.typ == cfbTypeStorage:
+			recip, err := readMsgObject(f, seen, i, 8, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj.recips = append(obj.recips, recip)
+		case strings.HasPrefix(e.name, "__attach_version1.0_") && e.typ == cfbTypeStorage:
+			attach, err := readMsgObject(f, seen, i, 8, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj.attachs = append(obj.attachs, attach)
+		}
+	}
+
+	cp := obj.long(msgPropInternetCPID)
+	if cp == 0 {
+		cp = obj.long(msgPropMessageCodepage)
+	}
+	obj.codepage = msgCodepages[cp]
+	for _, sub := range append(obj.recips, obj.attachs...) {
+		// Recipients and attachments use the code page of their message
+		if sub.codepage == "" {
+			sub.codepage = obj.codepage
+		}
+	}
+	return obj, nil
+}
+
+// long returns the value of a PT_LONG property, or 0 if it is not present
+func (o *msgObject) long(id uint16) int {
+	p, ok := o.props[id]
+	if !ok || p.typ != msgTypeLong || len(p.data) < 4 {
+		return 0
+	}
+	return int(int32(binary.LittleEndian.Uint32(p.data)))
+}
+
+// sysTime returns the value of a PT_SYSTIME property, or the zero time if it is not present
+func (o *msgObject) sysTime(id uint16) time.Time {
+	p, ok := o.props[id]
+	if !ok || p.typ != msgTypeSysTime || len(p.data) < 8 {
+		return time.Time{}
+	}
+	// FILETIME counts 100ns intervals since 1601-01-01
+	ft := int64(binary.LittleEndian.Uint64(p.data))
+	const epochDiff = 116444736000000000
+	return time.Unix(0, (ft-epochDiff)*100).UTC()
+}
+
+// bin returns the value of a PT_BINARY property
+func (o *msgObject) bin(id uint16) []byte {
+	if p, ok := o.props[id]; ok && p.typ == msgTypeBinary {
+		return p.data
+	}
+	return nil
+}
+
+// str returns the value of a string property converted to UTF-8
+func (o *msgObject) str(id uint16) string {
+	p, ok := o.props[id]
+	if !ok {
+		return ""
+	}
+	switch p.typ {
+	case msgTypeUnicode:
+		u := make([]uint16, 0, len(p.data)/2)
+		for i := 0; i+1 < len(p.data); i += 2 {
+			u = append(u, binary.LittleEndian.Uint16(p.data[i:]))
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	case msgTypeString8, msgTypeBinary:
+		return strings.TrimRight(o.decodeString8(p.data), "\x00")
+	}
+	return ""
+}
+
+// decodeString8 converts 8-bit data in the message code page to UTF-8
+func (o *msgObject) decodeString8(data []byte) string {
+	if o.codepage != "" && o.codepage != "utf-8" {
+		if cs := mahonia.GetCharset(o.codepage); cs != nil {
+			return cs.NewDecoder().ConvertString(string(data))
+		}
+	}
+	return string(data)
+}
+
+// toMIMEBody converts the message object to a MIMEBody with a MIMEPart tree
+func (o *msgObject) toMIMEBody(depth int) (*MIMEBody, error) {
+	header := o.header()
+	mimeMsg := &MIMEBody{
+		Text: o.str(msgPropBody),
+		Html: o.str(msgPropBodyHTML),
+	}
+
+	// Body parts
+	var body *memMIMEPart
+	var textPart, htmlPart *memMIMEPart
+	if mimeMsg.Text != "" || mimeMsg.Html == "" {
+		textPart = newTextPart("text/plain", mimeMsg.Text)
+	}
+	if mimeMsg.Html != "" {
+		htmlPart = newTextPart("text/html", mimeMsg.Html)
+	}
+	switch {
+	case textPart != nil && htmlPart != nil:
+		body = NewMIMEPart(nil, "multipart/alternative")
+		body.header = make(textproto.MIMEHeader)
+		appendChild(body, textPart)
+		appendChild(body, htmlPart)
+	case htmlPart != nil:
+		body = htmlPart
+	default:
+		body = textPart
+	}
+
+	// Attachment parts
+	root := body
+	if len(o.attachs) > 0 {
+		root = NewMIMEPart(nil, "multipart/mixed")
+		root.header = make(textproto.MIMEHeader)
+		appendChild(root, body)
+		for _, a := range o.attachs {
+			p, err := a.toMIMEPart(mimeMsg.Html, depth)
+			if err != nil {
+				return nil, err
+			}
+			appendChild(root, p)
+		}
+	}
+
+	// The root header carries the message header along with its own content header
+	for k, v := range root.header {
+		header[k] = v
+	}
+	if strings.HasPrefix(root.contentType, "multipart/") {
+		header.Set("Content-Type", root.contentType)
+	}
+	root.header = header
+	mimeMsg.header = mail.Header(header)
+	mimeMsg.Root = root
+
+	mimeMsg.Attachments = BreadthMatchAll(root, func(p MIMEPart) bool {
+		return p.Disposition() == "attachment"
+	})
+	mimeMsg.Inlines = BreadthMatchAll(root, func(p MIMEPart) bool {
+		return p.Disposition() == "inline"
+	})
+
+	return mimeMsg, nil
+}
+
+// header returns the message header from the preserved transport headers, or built from
+// properties when they are absent.  Content headers are removed, as they describe the
+// original MIME structure rather than ours.
+func (o *msgObject) header() textproto.MIMEHeader {
+	if raw := o.str(msgPropTransportHeaders); strings.TrimSpace(raw) != "" {
+		raw = strings.TrimRight(raw, "\r\n") + "\r\n\r\n"
+		tr := textproto.NewReader(bufio.NewReader(strings.NewReader(raw)))
+		if h, _ := tr.ReadMIMEHeader(); len(h) > 0 {
+			for k := range h {
+				if strings.HasPrefix(k, "Content-") || k == "Mime-Version" {
+					delete(h, k)
+				}
+			}
+			h.Set("Mime-Version", "1.0")
+			return h
+		}
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Mime-Version", "1.0")
+	if s := o.str(msgPropSubject); s != "" {
+		h.Set("Subject", mime.QEncoding.Encode("utf-8", s))
+	}
+	from := o.str(msgPropSenderSMTPAddress)
+	if from == "" {
+		from = o.str(msgPropSenderEmail)
+	}
+	if from != "" || o.str(msgPropSenderName) != "" {
+		h.Set("From", (&mail.Address{Name: o.str(msgPropSenderName), Address: from}).String())
+	}
+	var to, cc, bcc []string
+	for _, r := range o.recips {
+		addr := r.str(msgPropSMTPAddress)
+		if addr == "" {
+			addr = r.str(msgPropEmailAddress)
+		}
+		formatted := (&mail.Address{Name: r.str(msgPropDisplayName), Address: addr}).String()
+		switch r.long(msgPropRecipientType) {
+		case 2:
+			cc = append(cc, formatted)
+		case 3:
+			bcc = append(bcc, formatted)
+		default:
+			to = append(to, formatted)
+		}
+	}
+	for k, v := range map[string][]string{"To": to, "Cc": cc, "Bcc": bcc} {
+		if len(v) > 0 {
+			h.Set(k, strings.Join(v, ", "))
+		}
+	}
+	date := o.sysTime(msgPropClientSubmitTime)
+	if date.IsZero() {
+		date = o.sysTime(msgPropDeliveryTime)
+	}
+	if !date.IsZero() {
+		h.Set("Date", date.Format(time.RFC1123Z))
+	}
+	if id := o.str(msgPropInternetMessageID); id != "" {
+		h.Set("Message-Id", id)
+	}
+	return h
+}
+
+// toMIMEPart converts an attachment object to a MIMEPart.  html is the body of the message
+// containing the attachment, used to decide whether it is displayed inline.
+func (o *msgObject) toMIMEPart(html string, depth int) (*memMIMEPart, error) {
+	name := o.str(msgPropAttachLongName)
+	if name == "" {
+		name = o.str(msgPropAttachFilename)
+	}
+	if name == "" {
+		name = o.str(msgPropDisplayName)
+	}
+
+	var content []byte
+	ctype := strings.ToLower(o.str(msgPropAttachMimeTag))
+	if p, ok := o.props[msgPropAttachData]; ok && p.typ == msgTypeObject &&
+		o.long(msgPropAttachMethod) == msgAttachEmbedded {
+		// Embedded message, convert and serialize it
+		inner, err := readMsgObject(o.f, o.seen, p.storage, 24, o.depth+1)
+		if err != nil {
+			return nil, err
+		}
+		innerBody, err := inner.toMIMEBody(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		buf := new(bytes.Buffer)
+		if err := WriteMIME(buf, innerBody.Root); err != nil {
+			return nil, err
+		}
+		content = buf.Bytes()
+		ctype = "message/rfc822"
+		if name == "" {
+			name = inner.str(msgPropSubject)
+		}
+		if name != "" && filepath.Ext(name) != ".eml" {
+			name += ".eml"
+		}
+	} else {
+		content = o.bin(msgPropAttachData)
+	}
+	if ctype == "" {
+		ctype, _, _ = mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name)))
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	p := NewMIMEPart(nil, ctype)
+	p.header = make(textproto.MIMEHeader)
+	p.fileName = name
+	p.content = content
+	p.disposition = "attachment"
+	if cid := strings.Trim(o.str(msgPropAttachContentID), "<>"); cid != "" {
+		p.header.Set("Content-Id", "<"+cid+">")
+		if strings.Contains(html, "cid:"+cid) {
+			p.disposition = "inline"
+		}
+	}
+	var params map[string]string
+	if name != "" {
+		params = map[string]string{"name": name}
+	}
+	p.header.Set("Content-Type", mime.FormatMediaType(ctype, params))
+	p.header.Set("Content-Disposition", FormatDisposition(p.disposition, DispositionParams{
+		FileName:         name,
+		CreationDate:     o.sysTime(msgPropCreationTime),
+		ModificationDate: o.sysTime(msgPropLastModTime),
+	}))
+	if ctype != "message/rfc822" {
+		p.header.Set("Content-Transfer-Encoding", "base64")
+	}
+	return p, nil
+}
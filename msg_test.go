@@ -0,0 +1,144 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// msgProps returns a __properties_version1.0 stream holding the given PT_LONG properties,
+// after a header of hdrSize bytes
+func msgProps(hdrSize int, longs map[uint32]uint32) []byte {
+	data := make([]byte, hdrSize)
+	for tag, v := range longs {
+		entry := make([]byte, 16)
+		binary.LittleEndian.PutUint32(entry, tag)
+		binary.LittleEndian.PutUint32(entry[8:], v)
+		data = append(data, entry...)
+	}
+	return data
+}
+
+// testMsg returns a .msg file with a subject, sender, plain text body, one Cc recipient and
+// a PDF attachment
+func testMsg() []byte {
+	return buildCFB([]testEntry{
+		{name: "Root Entry", typ: cfbTypeRoot, children: []int{1, 2, 3, 4, 5, 9, 12}},
+		{name: "__properties_version1.0", typ: cfbTypeStream,
+			data: msgProps(32, map[uint32]uint32{0x3FFD0003: 1252})},
+		{name: "__substg1.0_0037001F", typ: cfbTypeStream, data: utf16LE("Quarterly report")},
+		{name: "__substg1.0_1000001F", typ: cfbTypeStream, data: utf16LE("See attached.\r\n")},
+		{name: "__substg1.0_0C1F001F", typ: cfbTypeStream, data: utf16LE("joe@example.com")},
+		{name: "__recip_version1.0_#00000000", typ: cfbTypeStorage, children: []int{6, 7, 8}},
+		{name: "__substg1.0_3001001F", typ: cfbTypeStream, data: utf16LE("Bob")},
+		{name: "__substg1.0_39FE001F", typ: cfbTypeStream, data: utf16LE("bob@example.org")},
+		{name: "__properties_version1.0", typ: cfbTypeStream,
+			data: msgProps(8, map[uint32]uint32{0x0C150003: 2})},
+		{name: "__attach_version1.0_#00000000", typ: cfbTypeStorage, children: []int{10, 11}},
+		{name: "__substg1.0_3707001F", typ: cfbTypeStream, data: utf16LE("report.pdf")},
+		{name: "__substg1.0_37010102", typ: cfbTypeStream,
+			data: bytes.Repeat([]byte("%PDF"), 300)},
+		{name: "__substg1.0_0C1A001E", typ: cfbTypeStream, data: []byte("Joe Sender")},
+	})
+}
+
+func TestParseOutlookMsg(t *testing.T) {
+	data := testMsg()
+	if !IsOutlookMsg(data) {
+		t.Fatal("IsOutlookMsg() = false")
+	}
+	m, err := ParseOutlookMsg(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseOutlookMsg() error: %v", err)
+	}
+
+	headers := []struct {
+		name, want string
+	}{
+		{"Subject", "Quarterly report"},
+		{"From", `"Joe Sender" <joe@example.com>`},
+		{"Cc", `"Bob" <bob@example.org>`},
+		{"To", ""},
+	}
+	for _, h := range headers {
+		if got := m.GetHeader(h.name); got != h.want {
+			t.Errorf("GetHeader(%q) = %q, want %q", h.name, got, h.want)
+		}
+	}
+	if m.Text != "See attached.\r\n" {
+		t.Errorf("Text = %q, want %q", m.Text, "See attached.\r\n")
+	}
+	if len(m.Attachments) != 1 {
+		t.Fatalf("got %v attachments, want 1", len(m.Attachments))
+	}
+	a := m.Attachments[0]
+	if a.ContentType() != "application/pdf" || a.FileName() != "report.pdf" ||
+		len(a.Content()) != 1200 {
+		t.Errorf("attachment is %v %q with %v bytes, want application/pdf \"report.pdf\" with "+
+			"1200 bytes", a.ContentType(), a.FileName(), len(a.Content()))
+	}
+	if a.PartID() != "2" {
+		t.Errorf("attachment PartID() = %q, want \"2\"", a.PartID())
+	}
+}
+
+func TestParseOutlookMsgEmbedded(t *testing.T) {
+	data := buildCFB([]testEntry{
+		{name: "Root Entry", typ: cfbTypeRoot, children: []int{1, 2}},
+		{name: "__substg1.0_0037001F", typ: cfbTypeStream, data: utf16LE("Fwd: lunch")},
+		{name: "__attach_version1.0_#00000000", typ: cfbTypeStorage, children: []int{3, 4}},
+		{name: "__properties_version1.0", typ: cfbTypeStream,
+			data: msgProps(8, map[uint32]uint32{0x37050003: msgAttachEmbedded})},
+		{name: "__substg1.0_3701000D", typ: cfbTypeStorage, children: []int{5, 6}},
+		{name: "__substg1.0_0037001F", typ: cfbTypeStream, data: utf16LE("Lunch")},
+		{name: "__substg1.0_1000001F", typ: cfbTypeStream, data: utf16LE("Noon?")},
+	})
+	m, err := ParseOutlookMsg(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseOutlookMsg() error: %v", err)
+	}
+	if len(m.Attachments) != 1 {
+		t.Fatalf("got %v attachments, want 1", len(m.Attachments))
+	}
+	a := m.Attachments[0]
+	if a.ContentType() != "message/rfc822" || a.FileName() != "Lunch.eml" {
+		t.Errorf("attachment is %v %q, want message/rfc822 \"Lunch.eml\"", a.ContentType(),
+			a.FileName())
+	}
+	if content := string(a.Content()); !strings.Contains(content, "Subject: Lunch\r\n") ||
+		!strings.Contains(content, "Noon?") {
+		t.Errorf("embedded message is %q, want its subject and body", content)
+	}
+}
+
+func TestParseOutlookMsgInvalid(t *testing.T) {
+	data := testMsg()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a compound file", []byte("From: joe@example.com\r\n\r\nhello")},
+		{"truncated header", data[:100]},
+		{"truncated sectors", data[:cfbHeaderSize+512]},
+		// An attachment storage listed as its own child
+		{"storage cycle", buildCFB([]testEntry{
+			{name: "Root Entry", typ: cfbTypeRoot, children: []int{1}},
+			{name: "__attach_version1.0_#00000000", typ: cfbTypeStorage, children: []int{1}},
+		})},
+		// An embedded message holding the attachment that embeds it
+		{"embedded cycle", buildCFB([]testEntry{
+			{name: "Root Entry", typ: cfbTypeRoot, children: []int{1}},
+			{name: "__attach_version1.0_#00000000", typ: cfbTypeStorage, children: []int{2, 3}},
+			{name: "__properties_version1.0", typ: cfbTypeStream,
+				data: msgProps(8, map[uint32]uint32{0x37050003: msgAttachEmbedded})},
+			{name: "__substg1.0_3701000D", typ: cfbTypeStorage, children: []int{1}},
+		})},
+	}
+	for _, tt := range tests {
+		if _, err := ParseOutlookMsg(bytes.NewReader(tt.data)); err == nil {
+			t.Errorf("%v: ParseOutlookMsg() returned no error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package enmime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"code.google.com/p/mahonia"
+)
+
+// OffsetMap maps byte offsets in the decoded content of a part to byte offsets in its body as
+// it appears in the message, after the part header, see ParseOptions.MapOffsets.  It lets a
+// search hit in decoded text be highlighted or quoted in the source.
+type OffsetMap struct {
+	runs       []offsetRun
+	decodedLen int
+	rawLen     int
+}
+
+// offsetRun is a span of decoded bytes starting at decoded, the first of which came from the
+// body between start and end.  The bytes of a linear run each come from the next byte of the
+// body, the bytes of any other run all come from the same character.
+type offsetRun struct {
+	decoded    int
+	start, end int
+	linear     bool
+}
+
+// span is the position in the body of the encoding of a decoded byte
+type span struct {
+	start, end int
+}
+
+// newOffsetMap compresses the spans of each decoded byte into runs
+func newOffsetMap(spans []span, rawLen int) *OffsetMap {
+	m := &OffsetMap{decodedLen: len(spans), rawLen: rawLen}
+	for i, sp := range spans {
+		if n := len(m.runs); n > 0 {
+			last := &m.runs[n-1]
+			prev := spans[i-1]
+			next := sp == span{prev.start + 1, prev.end + 1}
+			if i-last.decoded == 1 && (next || sp == prev) {
+				// The second byte of a run decides its kind
+				last.linear = next
+				continue
+			}
+			if last.linear && next || !last.linear && sp == prev {
+				continue
+			}
+		}
+		m.runs = append(m.runs, offsetRun{decoded: i, start: sp.start, end: sp.end})
+	}
+	return m
+}
+
+// Len returns the length of the decoded content the map covers.
+func (m *OffsetMap) Len() int {
+	return m.decodedLen
+}
+
+// Raw returns the offset in the body of the character that decoded to the byte at offset.  An
+// offset at or past the end of the decoded content maps to the end of the body.
+func (m *OffsetMap) Raw(offset int) int {
+	if offset >= m.decodedLen {
+		return m.rawLen
+	}
+	return m.span(offset).start
+}
+
+// RawRange returns the span of the body that decodes to the content from start to end,
+// including the whole encoding of the characters at either end.
+func (m *OffsetMap) RawRange(start, end int) (int, int) {
+	if end > m.decodedLen {
+		end = m.decodedLen
+	}
+	if end <= start {
+		return m.Raw(start), m.Raw(start)
+	}
+	return m.Raw(start), m.span(end - 1).end
+}
+
+// span returns the span of the byte at offset, which must be within the decoded content
+func (m *OffsetMap) span(offset int) span {
+	if offset < 0 {
+		offset = 0
+	}
+	i := sort.Search(len(m.runs), func(i int) bool { return m.runs[i].decoded > offset }) - 1
+	r := m.runs[i]
+	if r.linear {
+		n := offset - r.decoded
+		return span{r.start + n, r.end + n}
+	}
+	return span{r.start, r.end}
+}
+
+// Offsets returns the offset map of p, or nil if it was not parsed with
+// ParseOptions.MapOffsets.  The map applies to the content before line ending normalization,
+// see RawContent.
+func Offsets(p MIMEPart) *OffsetMap {
+	if mp, ok := p.(*memMIMEPart); ok {
+		return mp.offsets
+	}
+	return nil
+}
+
+// TextOffsets returns the offset map of Text, or nil if ParseOptions.MapOffsets was not set or
+// Text is not the unchanged content of one part.  The offsets are in the body of the part
+// Text was taken from, or of the message if it is text only.
+func (m *MIMEBody) TextOffsets() *OffsetMap {
+	return m.textOffsets
+}
+
+// partTextOffsets returns the offset map of p if its content is the same as before line
+// ending normalization
+func partTextOffsets(p MIMEPart) *OffsetMap {
+	if mp, ok := p.(*memMIMEPart); ok && mp.rawContent == nil {
+		return mp.offsets
+	}
+	return nil
+}
+
+// maps returns true if the content of p is to be decoded with an offset map
+func (pr *parser) maps(p *memMIMEPart) bool {
+	return pr.opts.MapOffsets && strings.HasPrefix(p.contentType, "text/") &&
+		p.header.Get("Content-Encoding") == ""
+}
+
+// decodeMapped decodes the content of p from reader, recording its offset map.  In lenient
+// mode a failure is recorded on the part as decodeIsolated does.
+func (pr *parser) decodeMapped(p *memMIMEPart, reader io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	data, offsets, err := pr.mapContent(p.header, raw)
+	if err != nil {
+		if !pr.opts.Lenient {
+			return nil, err
+		}
+		p.err = err
+		p.rawContent = raw
+		pr.warn(Warning{Category: WarnPartDecode, Header: "Content-Transfer-Encoding",
+			Value: p.header.Get("Content-Transfer-Encoding"), Message: err.Error()})
+		return nil, nil
+	}
+	p.offsets = offsets
+	return data, nil
+}
+
+// mapContent decodes raw according to the Content-Transfer-Encoding and charset of header,
+// returning the offset map along with the content.  Quoted-printable line breaks are kept as
+// they appear in raw.
+func (pr *parser) mapContent(header textproto.MIMEHeader, raw []byte) ([]byte, *OffsetMap,
+	error) {
+	var data []byte
+	var spans []span
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		data, spans = decodeQPSpans(raw)
+	case "base64":
+		data, spans = decodeBase64Spans(raw)
+	default:
+		data = raw
+		spans = make([]span, len(raw))
+		for i := range spans {
+			spans[i] = span{i, i + 1}
+		}
+	}
+	if charset := pr.charset(header); charset != "" {
+		cs := mahonia.GetCharset(charset)
+		if cs == nil {
+			return nil, nil, fmt.Errorf("Unknown (to mahonia) charset: %q", charset)
+		}
+		data, spans = decodeCharsetSpans(cs.NewDecoder(), data, spans)
+	}
+	pr.contentDecoded(header, len(data))
+	return data, newOffsetMap(spans, len(raw)), nil
+}
+
+// decodeQPSpans decodes quoted-printable data, returning the span in data of each byte
+func decodeQPSpans(data []byte) ([]byte, []span) {
+	out := make([]byte, 0, len(data))
+	spans := make([]span, 0, len(data))
+	for pos := 0; pos < len(data); {
+		end := bytes.IndexByte(data[pos:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += pos + 1
+		}
+		line := data[pos:end]
+		text := bytes.TrimRight(line, "\r\n")
+		eol := line[len(text):]
+		// Trailing white space is transport padding
+		text = bytes.TrimRight(text, " \t")
+		soft := bytes.HasSuffix(text, []byte("="))
+		if soft {
+			text = text[:len(text)-1]
+		}
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			if c == '=' {
+				if b, ok := unhex(text[i+1:]); ok {
+					out = append(out, b)
+					spans = append(spans, span{pos + i, pos + i + 3})
+					i += 2
+					continue
+				}
+			}
+			// Malformed escapes are kept literally
+			out = append(out, c)
+			spans = append(spans, span{pos + i, pos + i + 1})
+		}
+		if !soft {
+			for i, c := range eol {
+				out = append(out, c)
+				at := end - len(eol) + i
+				spans = append(spans, span{at, at + 1})
+			}
+		}
+		pos = end
+	}
+	return out, spans
+}
+
+// unhex decodes the two hex digits at the start of b
+func unhex(b []byte) (byte, bool) {
+	if len(b) < 2 {
+		return 0, false
+	}
+	var v byte
+	for _, c := range b[:2] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		default:
+			return 0, false
+		}
+		v = v<<4 | c
+	}
+	return v, true
+}
+
+// base64Alphabet is the standard base64 alphabet, characters outside it are skipped
+const base64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// decodeBase64Spans decodes base64 data, returning the span in data of the two characters
+// each byte is taken from
+func decodeBase64Spans(data []byte) ([]byte, []span) {
+	out := make([]byte, 0, len(data)*3/4)
+	spans := make([]span, 0, len(data)*3/4)
+	var group [4]byte
+	var at [4]int
+	n := 0
+	flush := func() {
+		v := uint32(group[0])<<18 | uint32(group[1])<<12 | uint32(group[2])<<6 | uint32(group[3])
+		for i := 0; i < n-1; i++ {
+			out = append(out, byte(v>>(16-8*i)))
+			spans = append(spans, span{at[i], at[i+1] + 1})
+		}
+		group, n = [4]byte{}, 0
+	}
+	for i, c := range data {
+		if c == '=' {
+			break
+		}
+		v := strings.IndexByte(base64Alphabet, c)
+		if v < 0 {
+			continue
+		}
+		group[n], at[n] = byte(v), i
+		if n++; n == 4 {
+			flush()
+		}
+	}
+	if n > 1 {
+		flush()
+	}
+	return out, spans
+}
+
+// decodeCharsetSpans converts data to UTF-8 with decoder, carrying the spans of the bytes
+// each character came from
+func decodeCharsetSpans(decoder mahonia.Decoder, data []byte, spans []span) ([]byte, []span) {
+	out := make([]byte, 0, len(data))
+	mapped := make([]span, 0, len(data))
+	var buf [utf8.UTFMax]byte
+	for pos := 0; pos < len(data); {
+		c, size, status := decoder(data[pos:])
+		switch status {
+		case mahonia.STATE_ONLY:
+			if size > 0 {
+				pos += size
+				continue
+			}
+		case mahonia.NO_ROOM:
+			// A character truncated by the end of the content
+			c, size = utf8.RuneError, len(data)-pos
+		case mahonia.INVALID_CHAR:
+			c = utf8.RuneError
+		}
+		if size <= 0 || pos+size > len(data) {
+			size = 1
+		}
+		n := utf8.EncodeRune(buf[:], c)
+		sp := span{spans[pos].start, spans[pos+size-1].end}
+		for i := 0; i < n; i++ {
+			mapped = append(mapped, sp)
+		}
+		out = append(out, buf[:n]...)
+		pos += size
+	}
+	return out, mapped
+}
@@ -0,0 +1,194 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMapOffsets(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		body     string
+		want     string // Decoded content
+		find     string // Decoded text to locate in the body
+		wantBody string // Body span of find
+	}{
+		{
+			name:     "identity",
+			header:   "Content-Type: text/plain\r\n",
+			body:     "Hello world\r\n",
+			want:     "Hello world\r\n",
+			find:     "world",
+			wantBody: "world",
+		},
+		{
+			name:     "base64",
+			header:   "Content-Type: text/html\r\nContent-Transfer-Encoding: base64\r\n",
+			body:     "PGI+aGk8L2I+\r\n",
+			want:     "<b>hi</b>",
+			find:     "hi",
+			wantBody: "aGk",
+		},
+		{
+			name:     "base64 across lines",
+			header:   "Content-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n",
+			body:     "aGVs\r\nbG8=\r\n",
+			want:     "hello",
+			find:     "ll",
+			wantBody: "Vs\r\nbG",
+		},
+		{
+			name:     "quoted-printable",
+			header:   "Content-Type: text/plain\r\nContent-Transfer-Encoding: quoted-printable\r\n",
+			body:     "a=3Db soft=\r\nbreak\r\n",
+			want:     "a=b softbreak\r\n",
+			find:     "=b",
+			wantBody: "=3Db",
+		},
+		{
+			name:     "charset",
+			header:   "Content-Type: text/plain; charset=iso-8859-1\r\n",
+			body:     "Caf\xe9 au lait",
+			want:     "Café au lait",
+			find:     "é",
+			wantBody: "\xe9",
+		},
+	}
+	for _, tt := range tests {
+		raw := "Content-Type: multipart/mixed; boundary=X\r\n\r\n--X\r\n" + tt.header + "\r\n" +
+			tt.body + "\r\n--X--\r\n"
+		root, err := ParseMIMEWithOptions(bufio.NewReader(strings.NewReader(raw)),
+			&ParseOptions{MapOffsets: true})
+		if err != nil {
+			t.Errorf("%v: ParseMIMEWithOptions() error: %v", tt.name, err)
+			continue
+		}
+		p := root.FirstChild()
+		if string(p.Content()) != tt.want {
+			t.Errorf("%v: content = %q, want %q", tt.name, p.Content(), tt.want)
+			continue
+		}
+		m := Offsets(p)
+		if m == nil || m.Len() != len(tt.want) {
+			t.Errorf("%v: Offsets() = %v, want a map of %v bytes", tt.name, m, len(tt.want))
+			continue
+		}
+		i := strings.Index(tt.want, tt.find)
+		start, end := m.RawRange(i, i+len(tt.find))
+		if got := tt.body[start:end]; got != tt.wantBody {
+			t.Errorf("%v: RawRange(%v, %v) = %q, want %q", tt.name, i, i+len(tt.find), got,
+				tt.wantBody)
+		}
+		if got := m.Raw(len(tt.want)); got != len(tt.body) {
+			t.Errorf("%v: Raw(end) = %v, want %v", tt.name, got, len(tt.body))
+		}
+		if ns, ne := m.RawRange(i, i); ns != start || ne != start {
+			t.Errorf("%v: empty RawRange() = %v, %v, want %v, %v", tt.name, ns, ne, start, start)
+		}
+	}
+}
+
+func TestMapOffsetsDisabled(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=X\r\n\r\n--X\r\n" +
+		"Content-Type: text/plain\r\n\r\nhello\r\n--X\r\n" +
+		"Content-Type: image/png\r\n\r\nPNG\r\n--X--\r\n"
+	root, err := ParseMIME(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ParseMIME() error: %v", err)
+	}
+	if m := Offsets(root.FirstChild()); m != nil {
+		t.Errorf("Offsets() = %v without MapOffsets", m)
+	}
+	root, err = ParseMIMEWithOptions(bufio.NewReader(strings.NewReader(raw)),
+		&ParseOptions{MapOffsets: true})
+	if err != nil {
+		t.Fatalf("ParseMIMEWithOptions() error: %v", err)
+	}
+	if m := Offsets(root.FirstChild().NextSibling()); m != nil {
+		t.Errorf("Offsets() = %v for a non-text part", m)
+	}
+}
+
+func TestTextOffsets(t *testing.T) {
+	msg, err := mail.ReadMessage(strings.NewReader(
+		"Subject: x\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\na=3Db"))
+	if err != nil {
+		t.Fatalf("ReadMessage() error: %v", err)
+	}
+	mb, err := ParseMIMEBodyWithOptions(msg, &ParseOptions{MapOffsets: true})
+	if err != nil {
+		t.Fatalf("ParseMIMEBodyWithOptions() error: %v", err)
+	}
+	if mb.Text != "a=b" {
+		t.Fatalf("Text = %q, want \"a=b\"", mb.Text)
+	}
+	m := mb.TextOffsets()
+	if m == nil {
+		t.Fatal("TextOffsets() = nil")
+	}
+	if got := m.Raw(2); got != 4 {
+		t.Errorf("Raw(2) = %v, want 4", got)
+	}
+	if start, end := m.RawRange(1, 2); start != 1 || end != 4 {
+		t.Errorf("RawRange(1, 2) = %v, %v, want 1, 4", start, end)
+	}
+}
+
+func TestDecodeOffsets(t *testing.T) {
+	spans := []span{{0, 1}, {1, 2}, {2, 5}, {2, 5}, {5, 6}}
+	m := newOffsetMap(spans, 6)
+	restored, err := decodeOffsets(encodeOffsets(m))
+	if err != nil {
+		t.Fatalf("decodeOffsets() error: %v", err)
+	}
+	for i, sp := range spans {
+		if start, end := restored.RawRange(i, i+1); start != sp.start || end != sp.end {
+			t.Errorf("RawRange(%v) = %v, %v, want %v, %v", i, start, end, sp.start, sp.end)
+		}
+	}
+
+	tests := []struct {
+		name string
+		c    cachedOffsets
+	}{
+		{"negative length", cachedOffsets{DecodedLen: -1}},
+		{"no runs", cachedOffsets{DecodedLen: 2, RawLen: 2}},
+		{"first run", cachedOffsets{
+			Runs: []cachedRun{{Decoded: 1, End: 1}}, DecodedLen: 2, RawLen: 2}},
+		{"out of order", cachedOffsets{
+			Runs: []cachedRun{{End: 1}, {End: 1}}, DecodedLen: 2, RawLen: 2}},
+		{"past content", cachedOffsets{
+			Runs: []cachedRun{{End: 1}, {Decoded: 2, End: 1}}, DecodedLen: 2, RawLen: 2}},
+		{"past body", cachedOffsets{
+			Runs: []cachedRun{{Start: 1, End: 3}}, DecodedLen: 1, RawLen: 2}},
+		{"linear run past body", cachedOffsets{
+			Runs: []cachedRun{{End: 1, Linear: true}}, DecodedLen: 3, RawLen: 2}},
+	}
+	for _, tt := range tests {
+		if _, err := decodeOffsets(&tt.c); err == nil {
+			t.Errorf("%v: decodeOffsets() returned no error", tt.name)
+		}
+	}
+	if m, err := decodeOffsets(nil); m != nil || err != nil {
+		t.Errorf("decodeOffsets(nil) = %v, %v, want nil, nil", m, err)
+	}
+}
+
+func TestDecodeBase64Spans(t *testing.T) {
+	body := []byte("SGVs\r\nbG8g\r\nd29y bGQ=\r\n")
+	data, spans := decodeBase64Spans(body)
+	if string(data) != "Hello world" || len(spans) != len(data) {
+		t.Fatalf("decodeBase64Spans() = %q with %v spans", data, len(spans))
+	}
+	for i, sp := range spans {
+		// Each byte starts at a base64 character of the body
+		if sp.start >= sp.end || sp.end > len(body) ||
+			bytes.ContainsAny(body[sp.start:sp.start+1], "\r\n =") {
+			t.Errorf("byte %v has span %v of %q", i, sp, body)
+		}
+	}
+}
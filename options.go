@@ -0,0 +1,150 @@
+package enmime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+)
+
+// DefaultMaxDecompressedSize is the limit on the decompressed size of a part having a gzip
+// or deflate Content-Encoding, used when ParseOptions.MaxDecompressedSize is zero.
+const DefaultMaxDecompressedSize = 100 << 20
+
+// ErrParseStopped is returned when a PartFilter stops the parse.
+var ErrParseStopped = errors.New("Parse stopped by PartFilter")
+
+// PartAction tells the parser how to handle a part, see ParseOptions.PartFilter.
+type PartAction int
+
+const (
+	// PartDecode parses and decodes the part as usual
+	PartDecode PartAction = iota
+	// PartSkip keeps the part in the tree, but discards its body without decoding it; a
+	// multipart loses its children
+	PartSkip
+	// PartStop abandons the parse, which fails with ErrParseStopped
+	PartStop
+)
+
+// ParseOptions controls optional behavior of the parser.  The zero value gives the behavior
+// of ParseMIME and ParseMIMEBody.
+type ParseOptions struct {
+	// Lenient recovers from broken multipart structure that would otherwise fail the parse,
+	// reporting what it worked around as warnings: multiparts truncated before their
+	// terminating boundary are closed where the input ends, and a nested multipart reusing an
+	// enclosing boundary adopts the parts that follow it.  A part whose content fails to
+	// decode is kept with its error, see MIMEPart.Error, and parsing continues with its
+	// siblings.
+	Lenient bool
+
+	// DedupContent makes parts with byte-identical decoded content share a single buffer,
+	// saving memory on mail carrying the same attachment many times.  ContentShared reports
+	// which parts are affected; their content must not be modified in place.
+	DedupContent bool
+
+	// ExtractEncodedBlocks removes uuencoded and BinHex 4.0 blocks from the plain text body
+	// of a MIMEBody, adding the decoded files to its Attachments.
+	ExtractEncodedBlocks bool
+
+	// MaxDecompressedSize limits the size of a part after removing a gzip or deflate
+	// Content-Encoding; exceeding it fails the parse.  Zero selects
+	// DefaultMaxDecompressedSize.
+	MaxDecompressedSize int64
+
+	// QuotedPrintableMode selects how line breaks are decoded from quoted-printable parts.
+	// QPModeAuto, the default, chooses based on the part's Content-Type and the line breaks
+	// in its encoded data.
+	QuotedPrintableMode QPMode
+
+	// PartFilter, if set, is called for each part of a multipart once its header has been
+	// read, before its body is decoded.  The part has its ContentType, Disposition, FileName
+	// and Header but no content yet.  Skipping or stopping saves the work of decoding mail
+	// that will be rejected anyway.
+	PartFilter func(p MIMEPart) PartAction
+
+	// Scanners are run over the decoded content of every leaf part, their results are
+	// available from MIMEPart.ScanResults once the parse completes.  The body of a text only
+	// message is scanned as well, its results are in MIMEBody.Scans.
+	Scanners []PartScanner
+
+	// DefaultCharset is the charset of text parts that do not declare one, such as
+	// "windows-1252" or "iso-2022-jp".  Their content is converted to UTF-8 from it rather
+	// than being kept as it was.
+	DefaultCharset string
+
+	// LineEndings normalizes the line breaks of text/* parts after decoding, binary parts are
+	// left untouched.  RawContent returns the content as it was before normalization.
+	LineEndings LineEnding
+
+	// KeepRawBody makes ParseMIMEBody keep the undecoded body of the message, which
+	// MIMEBody.CanonicalBody needs to reproduce what a DKIM signer hashed.
+	KeepRawBody bool
+
+	// Storage, if set, receives the decoded content of attachments and other non-text leaf
+	// parts as they are parsed, rather than it being held in memory.  Content and OpenContent
+	// read it back from the storage.  Lenient parses keep all content in memory, and stored
+	// parts are not shared by DedupContent.
+	Storage Storage
+
+	// MapOffsets records for each text part an OffsetMap from its decoded content back to its
+	// undecoded body, see Offsets and MIMEBody.TextOffsets.  Mapped parts are decoded with
+	// their quoted-printable line breaks as they appear, whatever the QuotedPrintableMode.
+	MapOffsets bool
+
+	// OnWarning, if set, is called for each anomaly the parser works around.  ParseMIMEBody
+	// also collects them in MIMEBody.Warnings.
+	OnWarning func(Warning)
+
+	// Metrics, if set, receives counts of parsed parts, decoded bytes and charset
+	// conversions, and the duration of each parse.
+	Metrics Metrics
+}
+
+// parser holds the options and state of a single parse
+type parser struct {
+	opts       ParseOptions
+	hashes     map[[sha256.Size]byte]*memMIMEPart // First part seen with each content hash
+	warnings   []Warning
+	metrics    Metrics
+	path       []int    // Section number of the part being parsed
+	partType   string   // Content-Type of the part being parsed
+	boundaries []string // Boundaries of the enclosing multiparts
+}
+
+// newParser returns a parser configured by opts, which may be nil
+func newParser(opts *ParseOptions) *parser {
+	pr := &parser{metrics: nopMetrics{}}
+	if opts != nil {
+		pr.opts = *opts
+		if opts.Metrics != nil {
+			pr.metrics = opts.Metrics
+		}
+	}
+	return pr
+}
+
+// setContent stores the decoded content of part, normalizing the line endings of text and
+// sharing the buffer of an identical part parsed earlier when those options are enabled
+func (pr *parser) setContent(part *memMIMEPart, content []byte) {
+	if strings.HasPrefix(part.contentType, "text/") &&
+		!conformsLineEndings(content, pr.opts.LineEndings) {
+		part.rawContent = content
+		content = normalizeLineEndings(content, pr.opts.LineEndings)
+	}
+	part.content = content
+	if !pr.opts.DedupContent || len(content) == 0 {
+		return
+	}
+	if pr.hashes == nil {
+		pr.hashes = make(map[[sha256.Size]byte]*memMIMEPart)
+	}
+	sum := sha256.Sum256(content)
+	if first, ok := pr.hashes[sum]; ok && bytes.Equal(first.content, content) {
+		part.content = first.content
+		part.shared = true
+		first.shared = true
+		return
+	}
+	pr.hashes[sum] = part
+}
@@ -3,6 +3,9 @@ package enmime
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -10,9 +13,9 @@ import (
 	"mime/multipart"
 	"net/textproto"
 	"strings"
+	"time"
 
 	"code.google.com/p/mahonia"
-	"github.com/sloonz/go-qprintable"
 )
 
 // MIMEPart is the primary interface enmine clients will use.  Each MIMEPart represents
@@ -29,20 +32,38 @@ type MIMEPart interface {
 	ContentType() string          // Content-Type header without parameters
 	Disposition() string          // Content-Disposition header without parameters
 	FileName() string             // File Name from disposition or type header
+	PartID() string               // IMAP style section number, such as "2.1"; "" for the root
 	Content() []byte              // Decoded content of this part (can be empty)
+	RawContent() []byte           // Content before normalization, or undecoded if Error is set
+	Error() error                 // Decoding error kept by lenient parsing, or nil
+	ScanResults() []ScanResult    // Verdicts of the PartScanners run over this part
+	ContentShared() bool          // True if Content is shared with identical parts
+	DeepCopy() MIMEPart           // Copy of this part and its descendants
 }
 
 // memMIMEPart is an in-memory implementation of the MIMEPart interface.  It will likely
 // choke on huge attachments.
 type memMIMEPart struct {
-	parent      MIMEPart
-	firstChild  MIMEPart
-	nextSibling MIMEPart
-	header      textproto.MIMEHeader
-	contentType string
-	disposition string
-	fileName    string
-	content     []byte
+	parent       MIMEPart
+	firstChild   MIMEPart
+	nextSibling  MIMEPart
+	header       textproto.MIMEHeader
+	contentType  string
+	disposition  string
+	fileName     string
+	partID       string
+	content      []byte
+	rawContent   []byte // Content before line ending normalization, nil if unchanged
+	err          error  // Error decoding the content, in lenient mode
+	scans        []ScanResult
+	rawSize      int     // Size of the undecoded content in bytes
+	rawLines     int     // Number of lines in the undecoded content
+	shared       bool    // Content buffer is shared with other parts
+	storage      Storage // Holds the content in place of content, if set
+	storageKey   string
+	offsets      *OffsetMap // Offsets of the content in the undecoded body, if mapped
+	charset      string     // Charset the content was converted to UTF-8 from, if any
+	decompressed bool       // The Content-Encoding was removed from the content
 }
 
 // NewMIMEPart creates a new memMIMEPart object.  It does not update the parents FirstChild
@@ -51,6 +72,31 @@ func NewMIMEPart(parent MIMEPart, contentType string) *memMIMEPart {
 	return &memMIMEPart{parent: parent, contentType: contentType}
 }
 
+// newTextPart returns a UTF-8 text part with the given content
+func newTextPart(ctype, text string) *memMIMEPart {
+	p := NewMIMEPart(nil, ctype)
+	p.header = make(textproto.MIMEHeader)
+	p.header.Set("Content-Type", mime.FormatMediaType(ctype, map[string]string{"charset": "utf-8"}))
+	p.header.Set("Content-Transfer-Encoding", "quoted-printable")
+	p.content = []byte(text)
+	return p
+}
+
+// appendChild adds child as the last child of parent
+func appendChild(parent, child *memMIMEPart) {
+	child.parent = parent
+	child.nextSibling = nil
+	if parent.firstChild == nil {
+		parent.firstChild = child
+		return
+	}
+	c := parent.firstChild.(*memMIMEPart)
+	for c.nextSibling != nil {
+		c = c.nextSibling.(*memMIMEPart)
+	}
+	c.nextSibling = child
+}
+
 // Parent of this part (can be nil)
 func (p *memMIMEPart) Parent() MIMEPart {
 	return p.parent
@@ -86,67 +132,150 @@ func (p *memMIMEPart) FileName() string {
 	return p.fileName
 }
 
+// IMAP style section number, such as "2.1", assigned when the part was parsed.  The root
+// part, and parts that were not parsed from a message, have "".
+func (p *memMIMEPart) PartID() string {
+	return p.partID
+}
+
 // Decoded content of this part (can be empty)
 func (p *memMIMEPart) Content() []byte {
+	if p.storage != nil {
+		// Loaded on every call, so that the content is not kept in memory
+		r, err := p.storage.Open(p.storageKey)
+		if err != nil {
+			return nil
+		}
+		defer r.Close()
+		content, _ := io.ReadAll(r)
+		return content
+	}
+	return p.content
+}
+
+// Decoded content before line ending normalization, the same as Content unless
+// ParseOptions.LineEndings changed it.  If the part failed to decode, this is its body as it
+// appeared in the message.
+func (p *memMIMEPart) RawContent() []byte {
+	if p.rawContent != nil {
+		return p.rawContent
+	}
 	return p.content
 }
 
+// Error that prevented the content from being decoded.  Only lenient parsing keeps such
+// parts, their Content is empty.
+func (p *memMIMEPart) Error() error {
+	return p.err
+}
+
+// Verdicts of the PartScanners run over this part, in the order of ParseOptions.Scanners
+func (p *memMIMEPart) ScanResults() []ScanResult {
+	return p.scans
+}
+
+// True if Content is shared with identical parts
+func (p *memMIMEPart) ContentShared() bool {
+	return p.shared
+}
+
 // ParseMIME reads a MIME document from the provided reader and parses it into
 // tree of MIMEPart objects.
 func ParseMIME(reader *bufio.Reader) (MIMEPart, error) {
+	return ParseMIMEWithOptions(reader, nil)
+}
+
+// ParseMIMEWithOptions is like ParseMIME, with optional behavior controlled by opts.
+func ParseMIMEWithOptions(reader *bufio.Reader, opts *ParseOptions) (root MIMEPart, err error) {
+	pr := newParser(opts)
+	start := time.Now()
+	defer func() { pr.parseTimer(start, err) }()
+	defer pr.recoverPanic(&err)
+	return pr.parseMIME(reader)
+}
+
+// parseMIME reads and parses a MIME document
+func (pr *parser) parseMIME(reader *bufio.Reader) (MIMEPart, error) {
 	tr := textproto.NewReader(reader)
 	header, err := tr.ReadMIMEHeader()
 	if err != nil {
 		return nil, err
 	}
-	mediatype, params, err := mime.ParseMediaType(header.Get("Content-Type"))
-	if err != nil {
-		return nil, err
+	mediatype, params := "text/plain", map[string]string(nil)
+	if ctype := header.Get("Content-Type"); ctype != "" {
+		// Content-Type is optional, RFC 2045 specifies a default of text/plain
+		mediatype, params, err = mime.ParseMediaType(ctype)
+		if err != nil {
+			return nil, err
+		}
 	}
 	root := &memMIMEPart{header: header, contentType: mediatype}
-	println(params)
+	pr.metrics.PartParsed(mediatype)
+	pr.partType = mediatype
 
 	if strings.HasPrefix(mediatype, "multipart/") {
 		boundary := params["boundary"]
-		err = parseParts(root, reader, boundary)
-		if err != nil {
-			return nil, err
+		if boundary == "" {
+			return nil, fmt.Errorf("Unable to locate boundary param in Content-Type header")
 		}
-	} else {
-		// Content is text or data, decode it
-		content, err := decodeSection(header.Get("Content-Transfer-Encoding"), header.Get("charset"), reader)
+		err = pr.parseParts(root, reader, boundary)
 		if err != nil {
 			return nil, err
 		}
-		root.content = content
+	} else if err := pr.decodeLeaf(root, reader); err != nil {
+		return nil, err
 	}
 
 	return root, nil
 }
 
 // parseParts recursively parses a mime multipart document.
-func parseParts(parent *memMIMEPart, reader io.Reader, boundary string) error {
+func (pr *parser) parseParts(parent *memMIMEPart, reader io.Reader, boundary string) error {
+	if len(pr.path) >= maxPartDepth {
+		return fmt.Errorf("Multipart nesting exceeds %v levels at boundary %v", maxPartDepth,
+			boundary)
+	}
+	// Not deferred, a panic must leave the path of the failing part for recoverPanic
+	depth := len(pr.path)
+	pr.path = append(pr.path, 0)
+	pr.boundaries = append(pr.boundaries, boundary)
+	err := pr.parsePartList(parent, reader, boundary)
+	pr.path = pr.path[:depth]
+	pr.boundaries = pr.boundaries[:depth]
+	return err
+}
+
+// parsePartList parses the parts of a single multipart body
+func (pr *parser) parsePartList(parent *memMIMEPart, reader io.Reader, boundary string) error {
 	var prevSibling *memMIMEPart
 
 	// Loop over MIME parts
 	mr := multipart.NewReader(reader, boundary)
 	for {
 		// mrp is golang's built in mime-part
-		mrp, err := mr.NextPart()
+		mrp, err := mr.NextRawPart()
 		if err != nil {
 			if err == io.EOF {
 				// This is a clean end-of-message signal
 				break
 			}
+			if pr.opts.Lenient && strings.HasSuffix(err.Error(), "EOF") {
+				// Input ended, or the enclosing part did, before the terminating boundary
+				pr.warn(Warning{Category: WarnBoundaryClose, Value: boundary,
+					Message: "Multipart truncated before its terminating boundary"})
+				break
+			}
 			return err
 		}
 		if len(mrp.Header) == 0 {
 			// Empty header probably means the part didn't using the correct trailing "--"
 			// syntax to close its boundary.  We will let this slide if this this the
 			// last MIME part.
-			if _, err := mr.NextPart(); err != nil {
+			if _, err := mr.NextRawPart(); err != nil {
 				if err == io.EOF || strings.HasSuffix(err.Error(), "EOF") {
 					// This is what we were hoping for
+					pr.warn(Warning{Category: WarnBoundaryClose, Value: boundary,
+						Message: "Multipart not closed with a terminating boundary"})
 					break
 				} else {
 					return fmt.Errorf("Error at boundary %v: %v", boundary, err)
@@ -179,6 +308,10 @@ func parseParts(parent *memMIMEPart, reader io.Reader, boundary string) error {
 		// Insert ourselves into tree, p is enmime's mime-part
 		p := NewMIMEPart(parent, mediatype)
 		p.header = mrp.Header
+		pr.metrics.PartParsed(mediatype)
+		pr.path[len(pr.path)-1]++
+		pr.partType = mediatype
+		p.partID = pr.section()
 		if prevSibling != nil {
 			prevSibling.nextSibling = p
 		} else {
@@ -186,51 +319,136 @@ func parseParts(parent *memMIMEPart, reader io.Reader, boundary string) error {
 		}
 		prevSibling = p
 
-		// Figure out our disposition, filename
-		disposition, dparams, err := mime.ParseMediaType(mrp.Header.Get("Content-Disposition"))
-		if err == nil {
-			// Disposition is optional
-			p.disposition = disposition
-			p.fileName = decodeHeader(dparams["filename"])
-		}
-		if p.fileName == "" && mparams["name"] != "" {
-			p.fileName = decodeHeader(mparams["name"])
+		pr.setDisposition(p, mparams)
+		if skip, err := pr.filter(p, mrp); err != nil {
+			return err
+		} else if skip {
+			continue
 		}
 
 		boundary := mparams["boundary"]
+		if boundary != "" && pr.boundaryInUse(boundary) {
+			// The enclosing multipart already ended this part at the next delimiter, so the
+			// parts it intended to contain follow as siblings.
+			if !pr.opts.Lenient {
+				return fmt.Errorf("Nested multipart reuses boundary %v", boundary)
+			}
+			pr.warn(Warning{Category: WarnBoundaryReuse, Header: "Content-Type", Value: boundary,
+				Message: "Nested multipart reuses an enclosing boundary"})
+			parent, prevSibling = p, nil
+			pr.path = append(pr.path, 0)
+			continue
+		}
 		if boundary != "" {
 			// Content is another multipart
-			err = parseParts(p, mrp, boundary)
+			err = pr.parseParts(p, pr.truncatable(mrp), boundary)
 			if err != nil {
 				return err
 			}
-		} else {
-			// Content is text or data, decode it
-			data, err := decodeSection(mrp.Header.Get("Content-Transfer-Encoding"), mrp.Header.Get("charset"), mrp)
-			if err != nil {
-				return err
+			if mediatype == "multipart/appledouble" {
+				fixAppleDouble(p)
 			}
-			p.content = data
+		} else if err := pr.decodeLeaf(p, mrp); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// setDisposition sets the disposition and file name of p from its header and the parameters
+// of its Content-Type
+func (pr *parser) setDisposition(p *memMIMEPart, mparams map[string]string) {
+	cdisp := p.header.Get("Content-Disposition")
+	disposition, dparams, err := mime.ParseMediaType(cdisp)
+	if err == nil {
+		// Disposition is optional
+		p.disposition = disposition
+		p.fileName = pr.decodeHeader("Content-Disposition", dparams["filename"])
+	} else if cdisp != "" {
+		pr.warn(Warning{Category: WarnMalformedHeader, Header: "Content-Disposition",
+			Value: cdisp, Message: err.Error()})
+	}
+	if p.fileName == "" && mparams["name"] != "" {
+		p.fileName = pr.decodeHeader("Content-Type", mparams["name"])
+	}
+}
+
+// filter applies ParseOptions.PartFilter to p, returning true if the part is skipped, in
+// which case its body is read from reader and discarded
+func (pr *parser) filter(p *memMIMEPart, reader io.Reader) (bool, error) {
+	if pr.opts.PartFilter == nil {
+		return false, nil
+	}
+	switch pr.opts.PartFilter(p) {
+	case PartSkip:
+		cr := &countingReader{r: pr.truncatable(reader)}
+		if _, err := io.Copy(io.Discard, cr); err != nil {
+			return true, err
+		}
+		p.rawSize, p.rawLines = cr.bytes, cr.lines
+		return true, nil
+	case PartStop:
+		return true, ErrParseStopped
+	}
+	return false, nil
+}
+
+// decodeLeaf decodes the content of the leaf part p from reader, into storage if it is
+// configured for the part, then scans it
+func (pr *parser) decodeLeaf(p *memMIMEPart, reader io.Reader) error {
+	if pr.stores(p) {
+		// Content is data, decode it into storage
+		cr := &countingReader{r: reader}
+		if err := pr.store(p, cr); err != nil {
+			return err
+		}
+		p.rawSize, p.rawLines = cr.bytes, cr.lines
+		pr.decoded(p)
+		return nil
+	}
+
+	// Content is text or data, decode it
+	cr := &countingReader{r: pr.truncatable(reader)}
+	var data []byte
+	var err error
+	if pr.maps(p) {
+		data, err = pr.decodeMapped(p, cr)
+	} else if pr.opts.Lenient {
+		data, err = pr.decodeIsolated(p, cr)
+	} else {
+		data, err = pr.decodeContent(p.header, cr)
+	}
+	if err != nil {
+		return err
+	}
+	p.rawSize, p.rawLines = cr.bytes, cr.lines
+	if p.err != nil {
+		return nil
+	}
+	if p.contentType == "application/applesingle" {
+		data = decodeAppleSingle(p, data)
+	}
+	pr.decoded(p)
+	pr.setContent(p, data)
+	return pr.scan(p)
+}
+
+// decoded records the changes decoding made to the content of p beyond removing the transfer
+// encoding, so it can be labelled correctly when written
+func (pr *parser) decoded(p *memMIMEPart) {
+	p.charset = pr.charset(p.header)
+	switch strings.ToLower(strings.TrimSpace(p.header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip", "deflate":
+		p.decompressed = true
+	}
+}
+
 // decodeSection attempts to decode the data from reader using the algorithm listed in
 // the Content-Transfer-Encoding header, returning the raw data if it does not known
 // the encoding type.
 func decodeSection(encoding, charset string, reader io.Reader) ([]byte, error) {
-	// Default is to just read input into bytes
-	decoder := reader
-
-	switch strings.ToLower(encoding) {
-	case "quoted-printable":
-		decoder = qprintable.NewDecoder(qprintable.WindowsTextEncoding, reader)
-	case "base64":
-		cleaner := NewBase64Cleaner(reader)
-		decoder = base64.NewDecoder(base64.StdEncoding, cleaner)
-	}
+	decoder := transferDecoder(encoding, reader)
 
 	// Read bytes into buffer
 	buf := new(bytes.Buffer)
@@ -252,3 +470,226 @@ func decodeSection(encoding, charset string, reader io.Reader) ([]byte, error) {
 
 	return b, nil
 }
+
+// decodeContent decodes the data from reader according to the Content-Transfer-Encoding,
+// Content-Encoding and charset of the part header, see decodeSection.
+func (pr *parser) decodeContent(header textproto.MIMEHeader, reader io.Reader) ([]byte, error) {
+	decoder, err := pr.transferContentDecoder(header, reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeSection("", pr.charset(header), decoder)
+	if err != nil {
+		return nil, err
+	}
+	pr.contentDecoded(header, len(data))
+	return data, nil
+}
+
+// contentReader is like decodeContent, returning a reader of the decoded content rather than
+// reading it into memory.  The caller reports the decoded size with contentDecoded.
+func (pr *parser) contentReader(header textproto.MIMEHeader, reader io.Reader) (io.Reader, error) {
+	decoder, err := pr.transferContentDecoder(header, reader)
+	if err != nil {
+		return nil, err
+	}
+	if charset := pr.charset(header); charset != "" {
+		cs := mahonia.GetCharset(charset)
+		if cs == nil {
+			return nil, fmt.Errorf("Unknown (to mahonia) charset: %q", charset)
+		}
+		decoder = cs.NewDecoder().NewReader(decoder)
+	}
+	return decoder, nil
+}
+
+// transferContentDecoder returns a reader removing the Content-Transfer-Encoding and
+// Content-Encoding of the part header from reader
+func (pr *parser) transferContentDecoder(header textproto.MIMEHeader,
+	reader io.Reader) (io.Reader, error) {
+	var decoder io.Reader
+	if strings.EqualFold(header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		mediatype, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		decoder = qpDecoder(pr.opts.QuotedPrintableMode, mediatype, reader)
+	} else {
+		decoder = transferDecoder(header.Get("Content-Transfer-Encoding"), reader)
+	}
+	return pr.contentDecoder(header.Get("Content-Encoding"), decoder)
+}
+
+// charset returns the charset to convert the content of the part with header from: its
+// charset parameter, or ParseOptions.DefaultCharset for text declaring none
+func (pr *parser) charset(header textproto.MIMEHeader) string {
+	if charset := header.Get("charset"); charset != "" || pr.opts.DefaultCharset == "" {
+		return charset
+	}
+	ctype := header.Get("Content-Type")
+	if ctype == "" {
+		// Text by default
+		return pr.opts.DefaultCharset
+	}
+	mediatype, params, err := mime.ParseMediaType(ctype)
+	if err != nil || !strings.HasPrefix(mediatype, "text/") || params["charset"] != "" {
+		return ""
+	}
+	return pr.opts.DefaultCharset
+}
+
+// contentDecoded reports the decoding of size bytes of content to the metrics
+func (pr *parser) contentDecoded(header textproto.MIMEHeader, size int) {
+	encoding := strings.ToLower(header.Get("Content-Transfer-Encoding"))
+	if encoding == "" {
+		encoding = "7bit"
+	}
+	pr.metrics.BytesDecoded(encoding, size)
+	if charset := pr.charset(header); charset != "" {
+		pr.metrics.CharsetConverted(strings.ToLower(charset))
+	}
+}
+
+// decodeIsolated decodes the content of a part in lenient mode.  A failure to decode is
+// recorded on the part along with its undecoded body, rather than failing the parse.
+func (pr *parser) decodeIsolated(p *memMIMEPart, reader io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := pr.decodeContent(p.header, bytes.NewReader(raw))
+	if err != nil {
+		p.err = err
+		p.rawContent = raw
+		pr.warn(Warning{Category: WarnPartDecode, Header: "Content-Transfer-Encoding",
+			Value: p.header.Get("Content-Transfer-Encoding"), Message: err.Error()})
+		return nil, nil
+	}
+	return data, nil
+}
+
+// contentDecoder returns a reader that decompresses reader according to the Content-Encoding
+// header, which some systems apply to part bodies beneath the transfer encoding.  The output
+// is limited to guard against decompression bombs.
+func (pr *parser) contentDecoder(encoding string, reader io.Reader) (io.Reader, error) {
+	var decoder io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading gzip content: %v", err)
+		}
+		decoder = zr
+	case "deflate":
+		// Supposed to be zlib wrapped, but raw deflate streams are common
+		br := bufio.NewReader(reader)
+		if hdr, err := br.Peek(2); err == nil && hdr[0]&0x0F == 8 &&
+			(uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading deflate content: %v", err)
+			}
+			decoder = zr
+		} else {
+			decoder = flate.NewReader(br)
+		}
+	default:
+		return reader, nil
+	}
+
+	limit := pr.opts.MaxDecompressedSize
+	if limit == 0 {
+		limit = DefaultMaxDecompressedSize
+	}
+	return &sizeLimitReader{r: decoder, limit: limit, what: "Decompressed content"}, nil
+}
+
+// transferDecoder returns a reader that decodes reader using the algorithm listed in the
+// Content-Transfer-Encoding header, or reader itself if it does not know the encoding type.
+func transferDecoder(encoding string, reader io.Reader) io.Reader {
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		return qpDecoder(QPModeAuto, "", reader)
+	case "base64":
+		cleaner := NewBase64Cleaner(reader)
+		return base64.NewDecoder(base64.StdEncoding, cleaner)
+	}
+	return reader
+}
+
+// boundaryInUse returns true if boundary delimits an enclosing multipart
+func (pr *parser) boundaryInUse(boundary string) bool {
+	for _, b := range pr.boundaries {
+		if b == boundary {
+			return true
+		}
+	}
+	return false
+}
+
+// truncatable wraps the body of a part so that, in lenient mode, input ending before the
+// closing boundary ends the part instead of failing the parse
+func (pr *parser) truncatable(r io.Reader) io.Reader {
+	if !pr.opts.Lenient {
+		return r
+	}
+	return &truncatedReader{r: r}
+}
+
+// truncatedReader reports io.ErrUnexpectedEOF from r as a plain io.EOF
+type truncatedReader struct {
+	r io.Reader
+}
+
+// Read method for io.Reader interface.
+func (t *truncatedReader) Read(p []byte) (n int, err error) {
+	n, err = t.r.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// sizeLimitReader returns an error once more than limit bytes have been read from r, rather
+// than silently truncating like io.LimitedReader.
+type sizeLimitReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+	what  string // Description of the content for the error message
+}
+
+// Read method for io.Reader interface.
+func (l *sizeLimitReader) Read(p []byte) (n int, err error) {
+	if l.read > l.limit {
+		return 0, l.exceeded()
+	}
+	// Read at most one byte past the limit, so that we can detect it was exceeded
+	if max := l.limit - l.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err = l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n - int(l.read-l.limit), l.exceeded()
+	}
+	return n, err
+}
+
+// exceeded returns the error reported once the limit has been passed
+func (l *sizeLimitReader) exceeded() error {
+	return fmt.Errorf("%v exceeds size limit of %v bytes", l.what, l.limit)
+}
+
+// countingReader counts the bytes and lines that pass through it, so that the size of a
+// section can be known after it has been decoded.
+type countingReader struct {
+	r     io.Reader
+	bytes int
+	lines int
+}
+
+// Read method for io.Reader interface.
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.bytes += n
+	c.lines += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
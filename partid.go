@@ -0,0 +1,28 @@
+package enmime
+
+import (
+	"strconv"
+)
+
+// PartByID returns the part of the tree beneath root whose PartID is id, or nil if there is
+// none.  It lets applications refer to a part, such as an attachment to download, by a
+// stable identifier rather than its position in a tree walk.
+func PartByID(root MIMEPart, id string) MIMEPart {
+	return DepthMatchFirst(root, func(p MIMEPart) bool {
+		return p.PartID() == id
+	})
+}
+
+// assignPartIDs numbers the descendants of p, which has the given id, for trees that were not
+// built by the parser
+func assignPartIDs(p *memMIMEPart, id string) {
+	p.partID = id
+	if id != "" {
+		id += "."
+	}
+	n := 0
+	for c := p.firstChild; c != nil; c = c.NextSibling() {
+		n++
+		assignPartIDs(c.(*memMIMEPart), id+strconv.Itoa(n))
+	}
+}
@@ -0,0 +1,66 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/sloonz/go-qprintable"
+)
+
+// QPMode selects how the quoted-printable decoder treats line breaks.
+type QPMode int
+
+const (
+	// QPModeAuto decodes parts other than text and messages in binary mode, and text with the
+	// line breaks found in the encoded data
+	QPModeAuto QPMode = iota
+	// QPModeBinary treats the content as binary data, hard line breaks are not translated
+	QPModeBinary
+	// QPModeUnix decodes hard line breaks as LF
+	QPModeUnix
+	// QPModeWindows decodes hard line breaks as CRLF
+	QPModeWindows
+	// QPModeMac decodes hard line breaks as CR
+	QPModeMac
+)
+
+// qpSniffSize is the amount of encoded data examined to detect its line breaks
+const qpSniffSize = 4096
+
+// qpDecoder returns a reader that decodes quoted-printable data from reader in the given
+// mode, for a part of the given media type, which may be empty if unknown
+func qpDecoder(mode QPMode, mediatype string, reader io.Reader) io.Reader {
+	if mode == QPModeAuto {
+		if mediatype != "" && !strings.HasPrefix(mediatype, "text/") &&
+			!strings.HasPrefix(mediatype, "message/") {
+			mode = QPModeBinary
+		} else {
+			br := bufio.NewReaderSize(reader, qpSniffSize)
+			reader = br
+			head, _ := br.Peek(qpSniffSize)
+			switch {
+			case bytes.Contains(head, []byte("\r\n")):
+				mode = QPModeWindows
+			case bytes.IndexByte(head, '\n') >= 0:
+				mode = QPModeUnix
+			case bytes.IndexByte(head, '\r') >= 0:
+				mode = QPModeMac
+			default:
+				mode = QPModeWindows
+			}
+		}
+	}
+
+	enc := qprintable.WindowsTextEncoding
+	switch mode {
+	case QPModeBinary:
+		enc = qprintable.BinaryEncoding
+	case QPModeUnix:
+		enc = qprintable.UnixTextEncoding
+	case QPModeMac:
+		enc = qprintable.MacTextEncoding
+	}
+	return qprintable.NewDecoder(enc, reader)
+}
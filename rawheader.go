@@ -0,0 +1,279 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// RawHeader is the top-level header of a message as it appeared in the input.  Fields can be
+// added, changed and deleted, while every field left alone is written back byte for byte,
+// keeping its order, case and folding.  This avoids breaking DKIM signatures over unrelated
+// fields when a gateway rewrites a message.
+type RawHeader struct {
+	fields    []rawField
+	separator []byte // Blank line ending the header, empty if the input ended first
+	eol       string // Line ending used for new fields
+}
+
+// HeaderField is one occurrence of a header field, see RawHeader.Fields.
+type HeaderField struct {
+	Name  string // Canonical field name
+	Value string
+}
+
+// rawField is a header field including its continuation lines and line endings
+type rawField struct {
+	key string // Canonical field name, "" for a line that is not a field
+	raw []byte
+}
+
+// ReadRawHeader reads a message header from r, leaving r positioned at the start of the
+// body.
+func ReadRawHeader(r *bufio.Reader) (*RawHeader, error) {
+	h := &RawHeader{eol: "\r\n"}
+	first := true
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if err == io.EOF {
+				return h, nil
+			}
+			return nil, err
+		}
+		if first && bytes.HasSuffix(line, []byte("\n")) && !bytes.HasSuffix(line, []byte("\r\n")) {
+			h.eol = "\n"
+		}
+		first = false
+
+		switch {
+		case len(bytes.TrimRight(line, "\r\n")) == 0:
+			h.separator = line
+			return h, nil
+		case (line[0] == ' ' || line[0] == '\t') && len(h.fields) > 0:
+			// Continuation of a folded field
+			f := &h.fields[len(h.fields)-1]
+			f.raw = append(f.raw, line...)
+		default:
+			key := ""
+			if i := bytes.IndexByte(line, ':'); i > 0 {
+				key = textproto.CanonicalMIMEHeaderKey(string(bytes.TrimRight(line[:i], " \t")))
+			}
+			h.fields = append(h.fields, rawField{key: key, raw: line})
+		}
+		if err != nil {
+			// Input ended within the header
+			if err == io.EOF {
+				return h, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// Get returns the unfolded value of the first field with the given name, or "" if there is
+// none.
+func (h *RawHeader) Get(name string) string {
+	if v := h.Values(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values returns the unfolded values of all the fields with the given name, in order.
+func (h *RawHeader) Values(name string) []string {
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	var values []string
+	for _, f := range h.fields {
+		if f.key == key {
+			values = append(values, f.value())
+		}
+	}
+	return values
+}
+
+// Keys returns the field names in the order they appear, including repeats.
+func (h *RawHeader) Keys() []string {
+	keys := make([]string, 0, len(h.fields))
+	for _, f := range h.fields {
+		if f.key != "" {
+			keys = append(keys, f.key)
+		}
+	}
+	return keys
+}
+
+// Fields returns the name and unfolded value of every field in the order they appear.
+func (h *RawHeader) Fields() []HeaderField {
+	fields := make([]HeaderField, 0, len(h.fields))
+	for _, f := range h.fields {
+		if f.key != "" {
+			fields = append(fields, HeaderField{Name: f.key, Value: f.value()})
+		}
+	}
+	return fields
+}
+
+// Add appends a field after the existing ones.  It returns an error, leaving the header
+// unchanged, if name is not a valid field name.
+func (h *RawHeader) Add(name, value string) error {
+	f, err := h.newField(name, value)
+	if err != nil {
+		return err
+	}
+	h.terminate()
+	h.fields = append(h.fields, f)
+	return nil
+}
+
+// Prepend inserts a field before the existing ones, where trace fields such as Received
+// belong.  It returns an error, leaving the header unchanged, if name is not a valid field
+// name.
+func (h *RawHeader) Prepend(name, value string) error {
+	f, err := h.newField(name, value)
+	if err != nil {
+		return err
+	}
+	h.fields = append([]rawField{f}, h.fields...)
+	return nil
+}
+
+// Set replaces the first field with the given name, in place, and deletes any others.  If
+// there is no such field it is added.  It returns an error, leaving the header unchanged, if
+// name is not a valid field name.
+func (h *RawHeader) Set(name, value string) error {
+	nf, err := h.newField(name, value)
+	if err != nil {
+		return err
+	}
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	fields := h.fields[:0]
+	replaced := false
+	for _, f := range h.fields {
+		if f.key != key {
+			fields = append(fields, f)
+		} else if !replaced {
+			fields = append(fields, nf)
+			replaced = true
+		}
+	}
+	h.fields = fields
+	if !replaced {
+		h.terminate()
+		h.fields = append(h.fields, nf)
+	}
+	return nil
+}
+
+// Del deletes every field with the given name.
+func (h *RawHeader) Del(name string) {
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	fields := h.fields[:0]
+	for _, f := range h.fields {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+	h.fields = fields
+}
+
+// WriteTo writes the header, including the blank line that ends it, to w.
+func (h *RawHeader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, f := range h.fields {
+		n, err := w.Write(f.raw)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	n, err := w.Write(h.separator)
+	total += int64(n)
+	return total, err
+}
+
+// RewriteHeader copies the message read from r to w, passing its header to edit on the way.
+// Fields that edit leaves alone, and the body, are copied unchanged.
+func RewriteHeader(w io.Writer, r io.Reader, edit func(h *RawHeader) error) error {
+	br := bufio.NewReader(r)
+	h, err := ReadRawHeader(br)
+	if err != nil {
+		return err
+	}
+	if err := edit(h); err != nil {
+		return err
+	}
+	if h.separator == nil {
+		// Input had no body, the header must still be terminated
+		h.terminate()
+		h.separator = []byte(h.eol)
+	}
+	if _, err := h.WriteTo(w); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, br)
+	return err
+}
+
+// newField formats a field added by the caller, using the line ending of the input.  The name
+// is written as given, and must be made of the printable characters RFC 5322 allows in field
+// names.  Line breaks in value become folds, so it cannot end the field or the header; breaks
+// with only white space after them are dropped.
+func (h *RawHeader) newField(name, value string) (rawField, error) {
+	if !validFieldName(name) {
+		return rawField{}, fmt.Errorf("Invalid header field name %q", name)
+	}
+	var b strings.Builder
+	b.WriteString(name + ": ")
+	lines := strings.FieldsFunc(value, func(r rune) bool { return r == '\r' || r == '\n' })
+	first := true
+	for _, line := range lines {
+		if !first {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			b.WriteString(h.eol)
+			if line[0] != ' ' && line[0] != '\t' {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(line)
+		first = false
+	}
+	b.WriteString(h.eol)
+	return rawField{key: textproto.CanonicalMIMEHeaderKey(name), raw: []byte(b.String())}, nil
+}
+
+// validFieldName returns true if name is a non-empty run of the printable US-ASCII
+// characters other than colon, the ftext of RFC 5322
+func validFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 33 || c > 126 || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// terminate adds a line ending to the last field if the input ended without one
+func (h *RawHeader) terminate() {
+	if n := len(h.fields); n > 0 && !bytes.HasSuffix(h.fields[n-1].raw, []byte("\n")) {
+		h.fields[n-1].raw = append(h.fields[n-1].raw, h.eol...)
+	}
+}
+
+// value returns the unfolded value of the field
+func (f rawField) value() string {
+	s := string(f.raw)
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		s = s[i+1:]
+	}
+	s = strings.NewReplacer("\r\n", "", "\n", "").Replace(s)
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,58 @@
+package enmime
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// maxPartDepth limits the nesting of multiparts, pathological input can otherwise nest them
+// deeply enough to exhaust the stack.
+const maxPartDepth = 100
+
+// PanicError is returned in place of a panic raised while parsing malformed input, so that
+// a bad message fails its own parse rather than crashing the process.
+type PanicError struct {
+	Value       interface{} // The value passed to panic
+	Section     string      // IMAP style section number of the part being parsed, "" for the root
+	ContentType string      // Content-Type of the part being parsed
+	Stack       []byte      // Stack trace of the goroutine at the time of the panic
+}
+
+// Error implements the error interface
+func (e *PanicError) Error() string {
+	section := e.Section
+	if section == "" {
+		section = "root"
+	}
+	return fmt.Sprintf("Panic while parsing part %v (%v): %v", section, e.ContentType, e.Value)
+}
+
+// recoverPanic converts a panic in progress into a *PanicError stored in err, it must be
+// called directly by a deferred function
+func (pr *parser) recoverPanic(err *error) {
+	if v := recover(); v != nil {
+		*err = &PanicError{
+			Value:       v,
+			Section:     pr.section(),
+			ContentType: pr.partType,
+			Stack:       stack(),
+		}
+	}
+}
+
+// section formats the position of the part being parsed like an IMAP section number
+func (pr *parser) section() string {
+	s := make([]string, len(pr.path))
+	for i, n := range pr.path {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ".")
+}
+
+// stack returns the stack trace of the calling goroutine
+func stack() []byte {
+	buf := make([]byte, 8192)
+	return buf[:runtime.Stack(buf, false)]
+}
@@ -0,0 +1,164 @@
+package enmime
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RemoteRef is a reference from an HTML body to a resource on another server, which a mail
+// client would fetch when displaying the message and so reveal that it was read.
+type RemoteRef struct {
+	Tag   string // Lower case name of the element, such as "img" or "style"
+	Attr  string // Lower case attribute holding the URL, or "url" for a CSS url() or @import
+	URL   string // The URL with HTML character references decoded
+	Start int    // Byte offset of the URL in the HTML, quotes excluded
+	End   int    // Byte offset just past the URL
+}
+
+// remoteAttrs lists the attributes that load a resource, by element.  Plain links are not
+// included as they are only fetched when clicked.
+var remoteAttrs = map[string][]string{
+	"img":    {"src", "lowsrc", "dynsrc"},
+	"image":  {"href", "xlink:href"},
+	"input":  {"src"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"frame":  {"src"},
+	"embed":  {"src"},
+	"object": {"data"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"source": {"src"},
+	"track":  {"src"},
+	"link":   {"href"},
+	"body":   {"background"},
+	"table":  {"background"},
+	"td":     {"background"},
+	"th":     {"background"},
+}
+
+var (
+	htmlTagRE  = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9:]*)((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+	htmlAttrRE = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9:_-]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	cssURLRE   = regexp.MustCompile(`(?i)url\(\s*("[^"]*"|'[^']*'|[^)"'\s]*)\s*\)|@import\s+("[^"]*"|'[^']*')`)
+	styleEndRE = regexp.MustCompile(`(?i)</style\s*>`)
+)
+
+// RemoteContent lists the remote resources referenced by an HTML body, in document order.
+// Only absolute http and https URLs, and protocol relative ones, are remote; cid: and data:
+// URLs refer to the message itself.
+func RemoteContent(body string) []RemoteRef {
+	return htmlRefs(body, isRemoteURL)
+}
+
+// htmlRefs lists the references from an HTML body whose URL is accepted by keep, in document
+// order
+func htmlRefs(body string, keep func(u string) bool) []RemoteRef {
+	var refs []RemoteRef
+	add := func(tag, attr string, start, end int) {
+		// Trim the quotes, which the value may include
+		if end > start && (body[start] == '"' || body[start] == '\'') {
+			start, end = start+1, end-1
+		}
+		u := strings.TrimSpace(body[start:end])
+		if !isRawText(tag, attr) {
+			u = html.UnescapeString(u)
+		}
+		if keep(u) {
+			refs = append(refs, RemoteRef{Tag: tag, Attr: attr, URL: u, Start: start, End: end})
+		}
+	}
+	addCSS := func(tag string, start, end int) {
+		for _, m := range cssURLRE.FindAllStringSubmatchIndex(body[start:end], -1) {
+			if m[2] >= 0 {
+				add(tag, "url", start+m[2], start+m[3])
+			} else {
+				add(tag, "url", start+m[4], start+m[5])
+			}
+		}
+	}
+
+	for _, t := range htmlTagRE.FindAllStringSubmatchIndex(body, -1) {
+		tag := strings.ToLower(body[t[2]:t[3]])
+		attrStart := t[4]
+		for _, a := range htmlAttrRE.FindAllStringSubmatchIndex(body[attrStart:t[5]], -1) {
+			name := strings.ToLower(body[attrStart+a[2] : attrStart+a[3]])
+			start, end := attrStart+a[4], attrStart+a[5]
+			if name == "style" {
+				addCSS(tag, start, end)
+				continue
+			}
+			for _, attr := range remoteAttrs[tag] {
+				if name == attr {
+					add(tag, name, start, end)
+				}
+			}
+		}
+		if tag == "style" {
+			end := len(body)
+			if loc := styleEndRE.FindStringIndex(body[t[1]:]); loc != nil {
+				end = t[1] + loc[0]
+			}
+			addCSS(tag, t[1], end)
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Start < refs[j].Start })
+	return refs
+}
+
+// isRemoteURL returns true if u would be fetched from another server
+func isRemoteURL(u string) bool {
+	lower := strings.ToLower(u)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") ||
+		strings.HasPrefix(lower, "//")
+}
+
+// isRawText returns true for references inside a style element, where character
+// references are not decoded
+func isRawText(tag, attr string) bool {
+	return tag == "style" && attr == "url"
+}
+
+// RewriteRemoteContent replaces the URL of every remote reference in an HTML body with the
+// one returned by rewrite, leaving the rest of the document untouched.  Returning the
+// original URL leaves a reference as it is, and returning "" blanks it.
+func RewriteRemoteContent(body string, rewrite func(ref RemoteRef) string) string {
+	return rewriteRefs(body, RemoteContent(body), rewrite)
+}
+
+// rewriteRefs replaces the URL of each of refs in an HTML body with the one returned by rewrite
+func rewriteRefs(body string, refs []RemoteRef, rewrite func(ref RemoteRef) string) string {
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		if ref.Start < last {
+			// Cannot happen for well formed matches, but never write overlapping spans
+			continue
+		}
+		b.WriteString(body[last:ref.Start])
+		u := rewrite(ref)
+		if !isRawText(ref.Tag, ref.Attr) {
+			u = html.EscapeString(u)
+		}
+		b.WriteString(u)
+		last = ref.End
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// ProxyURL returns a rewrite function for RewriteRemoteContent that routes each reference
+// through a proxy.  Every "{url}" in template is replaced with the query escaped original
+// URL, for example "https://proxy.example.com/fetch?u={url}".
+func ProxyURL(template string) func(ref RemoteRef) string {
+	return func(ref RemoteRef) string {
+		u := ref.URL
+		if strings.HasPrefix(u, "//") {
+			u = "https:" + u
+		}
+		return strings.Replace(template, "{url}", url.QueryEscape(u), -1)
+	}
+}
@@ -0,0 +1,233 @@
+package enmime
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// DSNRecipient is the per-recipient section of a delivery status notification.
+type DSNRecipient struct {
+	FinalRecipient    string    // Address the status applies to, required
+	OriginalRecipient string    // Address as originally given by the sender, if known
+	Action            string    // failed, delayed, delivered, relayed or expanded; required
+	Status            string    // RFC 3463 status code such as "5.1.1", required
+	RemoteMTA         string    // MTA that reported the status, such as "dns; mx.example.com"
+	DiagnosticCode    string    // Such as "smtp; 550 5.1.1 User unknown"
+	LastAttemptDate   time.Time // Time of the last delivery attempt, if known
+	WillRetryUntil    time.Time // For delayed messages, when delivery will be abandoned
+}
+
+// DSNOptions describes an RFC 3464 delivery status notification for BuildDSN.
+type DSNOptions struct {
+	From       string         // Address of the notification, such as the postmaster
+	To         string         // Envelope sender of the original message
+	Subject    string         // Defaults to "Delivery Status Notification"
+	Text       string         // Human readable explanation, the first part of the report
+	Date       time.Time      // Date of the notification, defaults to now
+	MessageID  string         // Message-ID of the notification, including angle brackets
+	Recipients []DSNRecipient // At least one recipient is required
+
+	ReportingMTA       string    // Such as "dns; mx.example.com", required
+	ReceivedFromMTA    string    // MTA the original message was received from, if known
+	OriginalEnvelopeID string    // ENVID given by the sender, if any
+	ArrivalDate        time.Time // When the original message arrived, if known
+
+	Original    []byte // The original message, as received
+	HeadersOnly bool   // Return only the header of Original, as text/rfc822-headers
+}
+
+// MDNOptions describes an RFC 8098 message disposition notification for BuildMDN.
+type MDNOptions struct {
+	From      string    // Address of the user the original message was sent to
+	To        string    // Address from the Disposition-Notification-To header
+	Subject   string    // Defaults to "Disposition Notification"
+	Text      string    // Human readable explanation, the first part of the report
+	Date      time.Time // Date of the notification, defaults to now
+	MessageID string    // Message-ID of the notification, including angle brackets
+
+	ReportingUA       string // User agent, such as "mail.example.com; enmime"
+	OriginalRecipient string // From the Original-Recipient header of the original, if any
+	FinalRecipient    string // Recipient whose disposition is reported, required
+	OriginalMessageID string // Message-ID of the original message, including angle brackets
+	// DispositionMode defaults to "automatic-action/MDN-sent-automatically"
+	DispositionMode string
+	// Disposition is displayed, deleted, dispatched or processed; required
+	Disposition string
+
+	Original    []byte // The original message, if it is to be returned
+	HeadersOnly bool   // Return only the header of Original, as text/rfc822-headers
+}
+
+// BuildDSN builds a multipart/report delivery status notification holding the explanation
+// text, the machine readable message/delivery-status, and the original message.  The result
+// can be written with WriteMIME.
+func BuildDSN(opts *DSNOptions) (MIMEPart, error) {
+	if opts.ReportingMTA == "" {
+		return nil, fmt.Errorf("DSN requires a Reporting-MTA")
+	}
+	if len(opts.Recipients) == 0 {
+		return nil, fmt.Errorf("DSN requires at least one recipient")
+	}
+
+	var status bytes.Buffer
+	writeReportField(&status, "Reporting-MTA", withType(opts.ReportingMTA, "dns"))
+	writeReportField(&status, "Original-Envelope-Id", opts.OriginalEnvelopeID)
+	writeReportField(&status, "Received-From-MTA", withType(opts.ReceivedFromMTA, "dns"))
+	writeReportField(&status, "Arrival-Date", formatReportDate(opts.ArrivalDate))
+	for i, r := range opts.Recipients {
+		if r.FinalRecipient == "" || r.Action == "" || r.Status == "" {
+			return nil, fmt.Errorf("DSN recipient %v requires Final-Recipient, Action and Status",
+				i+1)
+		}
+		status.WriteString("\r\n")
+		writeReportField(&status, "Original-Recipient", withType(r.OriginalRecipient, "rfc822"))
+		writeReportField(&status, "Final-Recipient", withType(r.FinalRecipient, "rfc822"))
+		writeReportField(&status, "Action", r.Action)
+		writeReportField(&status, "Status", r.Status)
+		writeReportField(&status, "Remote-MTA", withType(r.RemoteMTA, "dns"))
+		writeReportField(&status, "Diagnostic-Code", withType(r.DiagnosticCode, "smtp"))
+		writeReportField(&status, "Last-Attempt-Date", formatReportDate(r.LastAttemptDate))
+		writeReportField(&status, "Will-Retry-Until", formatReportDate(r.WillRetryUntil))
+	}
+
+	subject := opts.Subject
+	if subject == "" {
+		subject = "Delivery Status Notification"
+	}
+	return buildReport("delivery-status", "message/delivery-status", status.Bytes(),
+		reportHeader(opts.From, opts.To, subject, opts.MessageID, opts.Date), opts.Text,
+		opts.Original, opts.HeadersOnly), nil
+}
+
+// BuildMDN builds a multipart/report message disposition notification holding the
+// explanation text, the machine readable message/disposition-notification, and the original
+// message if one is given.  The result can be written with WriteMIME.
+func BuildMDN(opts *MDNOptions) (MIMEPart, error) {
+	if opts.FinalRecipient == "" {
+		return nil, fmt.Errorf("MDN requires a Final-Recipient")
+	}
+	if opts.Disposition == "" {
+		return nil, fmt.Errorf("MDN requires a Disposition")
+	}
+	mode := opts.DispositionMode
+	if mode == "" {
+		mode = "automatic-action/MDN-sent-automatically"
+	}
+
+	var notification bytes.Buffer
+	writeReportField(&notification, "Reporting-UA", opts.ReportingUA)
+	writeReportField(&notification, "Original-Recipient",
+		withType(opts.OriginalRecipient, "rfc822"))
+	writeReportField(&notification, "Final-Recipient", withType(opts.FinalRecipient, "rfc822"))
+	writeReportField(&notification, "Original-Message-ID", opts.OriginalMessageID)
+	writeReportField(&notification, "Disposition", mode+"; "+opts.Disposition)
+
+	subject := opts.Subject
+	if subject == "" {
+		subject = "Disposition Notification"
+	}
+	return buildReport("disposition-notification", "message/disposition-notification",
+		notification.Bytes(), reportHeader(opts.From, opts.To, subject, opts.MessageID, opts.Date),
+		opts.Text, opts.Original, opts.HeadersOnly), nil
+}
+
+// buildReport assembles the parts of a multipart/report
+func buildReport(reportType, ctype string, fields []byte, header textproto.MIMEHeader,
+	text string, original []byte, headersOnly bool) MIMEPart {
+	root := NewMIMEPart(nil, "multipart/report")
+	root.header = header
+	root.header.Set("Content-Type", mime.FormatMediaType("multipart/report",
+		map[string]string{"report-type": reportType}))
+	appendChild(root, newTextPart("text/plain", text))
+
+	report := NewMIMEPart(nil, ctype)
+	report.header = textproto.MIMEHeader{"Content-Type": {ctype}}
+	report.content = fields
+	appendChild(root, report)
+
+	if original != nil {
+		ctype := "message/rfc822"
+		if headersOnly {
+			ctype = "text/rfc822-headers"
+			original = headerBlock(original)
+		}
+		p := NewMIMEPart(nil, ctype)
+		p.header = textproto.MIMEHeader{"Content-Type": {ctype}}
+		if !isASCII(original) {
+			// message/rfc822 does not allow base64 or quoted-printable
+			p.header.Set("Content-Transfer-Encoding", "8bit")
+		}
+		p.content = original
+		appendChild(root, p)
+	}
+	return root
+}
+
+// reportHeader returns the top-level header of a report
+func reportHeader(from, to, subject, messageID string, date time.Time) textproto.MIMEHeader {
+	if date.IsZero() {
+		date = time.Now()
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Mime-Version", "1.0")
+	h.Set("Date", date.Format(time.RFC1123Z))
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	if from != "" {
+		h.Set("From", from)
+	}
+	if to != "" {
+		h.Set("To", to)
+	}
+	if messageID != "" {
+		h.Set("Message-Id", messageID)
+	}
+	return h
+}
+
+// writeReportField writes a machine readable report field, omitting empty values
+func writeReportField(b *bytes.Buffer, name, value string) {
+	if value != "" {
+		b.WriteString(name + ": " + value + "\r\n")
+	}
+}
+
+// withType prefixes value with the given address or diagnostic type, unless it has one
+func withType(value, typ string) string {
+	if value == "" || strings.Contains(value, ";") {
+		return value
+	}
+	return typ + "; " + value
+}
+
+// formatReportDate formats a date field value, or returns "" for the zero time
+func formatReportDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC1123Z)
+}
+
+// headerBlock returns the header of a message, including the blank line that ends it
+func headerBlock(msg []byte) []byte {
+	end := len(msg)
+	for _, sep := range []string{"\r\n\r\n", "\n\n"} {
+		if i := bytes.Index(msg, []byte(sep)); i >= 0 && i+len(sep) < end {
+			end = i + len(sep)
+		}
+	}
+	return msg[:end]
+}
+
+// isASCII returns true if b holds only 7 bit characters
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package enmime
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ResentEvent is one block of Resent-* fields, added each time a message was reintroduced
+// into the transport system by a user rather than replied to or forwarded.
+type ResentEvent struct {
+	Date      time.Time // Resent-Date, zero if missing or invalid
+	From      []*mail.Address
+	Sender    *mail.Address // Resent-Sender, if any
+	To        []*mail.Address
+	Cc        []*mail.Address
+	Bcc       []*mail.Address
+	MessageID string               // Resent-Message-ID, including angle brackets
+	Header    textproto.MIMEHeader // The raw fields of the block, RFC 2047 encoding intact
+}
+
+// ResentEvents returns the Resent-* blocks of the message header, most recent first, so they
+// can be told apart from each other and from the original From, To and Date.
+//
+// The header map does not record the order of different fields, so blocks are matched up by
+// position: the first value of each Resent-* field belongs to the first block, and so on.
+// That is exact when each block has the same fields, as Resent-Date and Resent-From are
+// required; RawHeader.ResentEvents handles blocks with differing optional fields.
+func (m *MIMEBody) ResentEvents() []ResentEvent {
+	var keys []string
+	blocks := 0
+	for k, v := range m.header {
+		if strings.HasPrefix(k, "Resent-") {
+			keys = append(keys, k)
+			if len(v) > blocks {
+				blocks = len(v)
+			}
+		}
+	}
+
+	var fields []headerField
+	for i := 0; i < blocks; i++ {
+		for _, k := range keys {
+			if i < len(m.header[k]) {
+				fields = append(fields, headerField{k, m.header[k][i]})
+			}
+		}
+		fields = append(fields, headerField{})
+	}
+	return resentEvents(fields)
+}
+
+// ResentEvents returns the Resent-* blocks of the header, most recent first.  Blocks are
+// delimited using the order of the fields, see MIMEBody.ResentEvents.
+func (h *RawHeader) ResentEvents() []ResentEvent {
+	fields := make([]headerField, len(h.fields))
+	for i, f := range h.fields {
+		fields[i] = headerField{f.key, f.value()}
+	}
+	return resentEvents(fields)
+}
+
+// headerField is a field name and value, a zero headerField separates Resent-* blocks
+type headerField struct {
+	key   string
+	value string
+}
+
+// resentEvents groups the Resent-* fields into blocks.  A block ends at any other field, or
+// when one of its fields repeats.
+func resentEvents(fields []headerField) []ResentEvent {
+	var events []ResentEvent
+	var cur textproto.MIMEHeader
+	flush := func() {
+		if cur != nil {
+			events = append(events, newResentEvent(cur))
+			cur = nil
+		}
+	}
+	for _, f := range fields {
+		if !strings.HasPrefix(f.key, "Resent-") {
+			flush()
+			continue
+		}
+		if _, repeated := cur[f.key]; cur == nil || repeated {
+			flush()
+			cur = make(textproto.MIMEHeader)
+		}
+		cur.Add(f.key, f.value)
+	}
+	flush()
+	return events
+}
+
+// newResentEvent interprets the fields of a Resent-* block
+func newResentEvent(h textproto.MIMEHeader) ResentEvent {
+	e := ResentEvent{Header: h, MessageID: strings.TrimSpace(h.Get("Resent-Message-Id"))}
+	e.Date, _ = mail.ParseDate(h.Get("Resent-Date"))
+	e.From = resentAddresses(h, "Resent-From")
+	e.To = resentAddresses(h, "Resent-To")
+	e.Cc = resentAddresses(h, "Resent-Cc")
+	e.Bcc = resentAddresses(h, "Resent-Bcc")
+	if s := resentAddresses(h, "Resent-Sender"); len(s) > 0 {
+		e.Sender = s[0]
+	}
+	return e
+}
+
+// resentAddresses parses an address list field, returning nil if it is missing or invalid
+func resentAddresses(h textproto.MIMEHeader, key string) []*mail.Address {
+	v := h.Get(key)
+	if v == "" {
+		return nil
+	}
+	list, err := mail.ParseAddressList(v)
+	if err != nil {
+		return nil
+	}
+	return list
+}
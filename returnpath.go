@@ -0,0 +1,128 @@
+package enmime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvelopeSender is the envelope sender of a message, as recorded in Return-Path by the
+// final delivery agent.  Forwarders using the Sender Rewriting Scheme and senders using
+// Bounce Address Tag Validation rewrite it, Original undoes both so bounces can be matched
+// to the address that actually sent the mail.
+type EnvelopeSender struct {
+	Raw      string // Address from Return-Path, "" for the null sender <>
+	Original string // Raw with SRS and BATV rewriting removed
+	SRS      bool   // Raw was rewritten by SRS (SRS0= or SRS1=)
+	BATV     bool   // Raw carried a BATV tag (prvs= or msprvs1=)
+}
+
+// Null returns true for the null envelope sender <>, used by bounces and other
+// notifications.
+func (e EnvelopeSender) Null() bool {
+	return e.Raw == ""
+}
+
+// EnvelopeSender returns the envelope sender from the first Return-Path of the message, and
+// false if there is none.
+func (m *MIMEBody) EnvelopeSender() (EnvelopeSender, bool) {
+	rp := m.header.Get("Return-Path")
+	if rp == "" {
+		return EnvelopeSender{}, false
+	}
+	e, err := ParseEnvelopeSender(rp)
+	if err != nil {
+		return EnvelopeSender{}, false
+	}
+	return e, true
+}
+
+// ParseEnvelopeSender parses a Return-Path value such as "<user@example.com>", undoing SRS
+// and BATV rewriting of the address.
+func ParseEnvelopeSender(returnPath string) (EnvelopeSender, error) {
+	addr := strings.TrimSpace(returnPath)
+	if i := strings.IndexByte(addr, '<'); i >= 0 {
+		j := strings.LastIndexByte(addr, '>')
+		if j < i {
+			return EnvelopeSender{}, fmt.Errorf("Unterminated Return-Path address: %q", returnPath)
+		}
+		addr = strings.TrimSpace(addr[i+1 : j])
+	}
+	if addr == "" {
+		return EnvelopeSender{}, nil
+	}
+	if k := strings.IndexByte(addr, ':'); k >= 0 && strings.HasPrefix(addr, "@") {
+		// Obsolete source route, @relay1,@relay2:user@example.com
+		addr = addr[k+1:]
+	}
+	if strings.LastIndexByte(addr, '@') <= 0 {
+		return EnvelopeSender{}, fmt.Errorf("Invalid Return-Path address: %q", returnPath)
+	}
+
+	e := EnvelopeSender{Raw: addr, Original: addr}
+	for {
+		if orig, ok := unwrapSRS(e.Original); ok {
+			e.Original, e.SRS = orig, true
+		} else if orig, ok := unwrapBATV(e.Original); ok {
+			e.Original, e.BATV = orig, true
+		} else {
+			return e, nil
+		}
+	}
+}
+
+// unwrapSRS returns the original address of an SRS0 or SRS1 rewritten address.
+//
+//	SRS0=hash=tt=orig.domain=orig-local@forwarder
+//	SRS1=hash=first.forwarder==hash=tt=orig.domain=orig-local@forwarder
+func unwrapSRS(addr string) (string, bool) {
+	at := strings.LastIndexByte(addr, '@')
+	local := addr[:at]
+	if len(local) < 5 || !isSRSSeparator(local[4]) {
+		return "", false
+	}
+	switch strings.ToUpper(local[:4]) {
+	case "SRS0":
+		local = local[5:]
+	case "SRS1":
+		// The SRS0 fields follow the first forwarder's domain, after a doubled separator
+		rest := local[5:]
+		i := strings.Index(rest, "==")
+		if i < 0 {
+			return "", false
+		}
+		local = rest[i+2:]
+	default:
+		return "", false
+	}
+	f := strings.SplitN(local, "=", 4)
+	if len(f) != 4 || f[2] == "" || f[3] == "" {
+		return "", false
+	}
+	return f[3] + "@" + f[2], true
+}
+
+// isSRSSeparator returns true for the characters that may follow SRS0 or SRS1
+func isSRSSeparator(c byte) bool {
+	return c == '=' || c == '+' || c == '-'
+}
+
+// unwrapBATV returns the original address of a BATV tagged address.
+//
+//	prvs=tag=local@domain
+//	msprvs1=tag=local@domain
+func unwrapBATV(addr string) (string, bool) {
+	at := strings.LastIndexByte(addr, '@')
+	local := addr[:at]
+	lower := strings.ToLower(local)
+	for _, prefix := range []string{"prvs=", "msprvs1="} {
+		if !strings.HasPrefix(lower, prefix) {
+			continue
+		}
+		f := strings.SplitN(local[len(prefix):], "=", 2)
+		if len(f) != 2 || f[0] == "" || f[1] == "" {
+			return "", false
+		}
+		return f[1] + addr[at:], true
+	}
+	return "", false
+}
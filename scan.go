@@ -0,0 +1,101 @@
+package enmime
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+)
+
+// ScanVerdict is the conclusion a PartScanner reached about a part.
+type ScanVerdict int
+
+const (
+	// ScanClean means nothing objectionable was found
+	ScanClean ScanVerdict = iota
+	// ScanSuspicious means the content may be harmful, see ScanResult.Score
+	ScanSuspicious
+	// ScanInfected means the content is known to be harmful
+	ScanInfected
+)
+
+// String returns the name of the verdict
+func (v ScanVerdict) String() string {
+	switch v {
+	case ScanClean:
+		return "clean"
+	case ScanSuspicious:
+		return "suspicious"
+	case ScanInfected:
+		return "infected"
+	}
+	return fmt.Sprintf("ScanVerdict(%d)", int(v))
+}
+
+// ScanResult is the outcome of scanning one part with one PartScanner.
+type ScanResult struct {
+	Scanner string      // Name of the scanner, as reported by PartScanner.Name
+	Verdict ScanVerdict // Conclusion of the scan
+	Score   float64     // Engine specific score, such as a spam or DLP score
+	Threat  string      // Name of the detected threat or policy, if any
+}
+
+// PartScanner is implemented by antivirus, DLP and similar engines to inspect each leaf part
+// as it is parsed, see ParseOptions.Scanners.
+type PartScanner interface {
+	// Name identifies the scanner in ScanResult
+	Name() string
+	// Scan reads the decoded content of p from r and reports its verdict.  The part has its
+	// header, ContentType, Disposition and FileName.  An error fails the parse.
+	Scan(p MIMEPart, r io.Reader) (ScanResult, error)
+}
+
+// Infected returns true if any scanner found p to be infected
+func Infected(p MIMEPart) bool {
+	for _, r := range p.ScanResults() {
+		if r.Verdict == ScanInfected {
+			return true
+		}
+	}
+	return false
+}
+
+// scan runs the configured scanners over the content of p, attaching their results
+func (pr *parser) scan(p *memMIMEPart) error {
+	for _, s := range pr.opts.Scanners {
+		r, err := OpenContent(p)
+		if err != nil {
+			return fmt.Errorf("Unable to open part %v for scanning: %v", p.partID, err)
+		}
+		result, err := s.Scan(p, r)
+		r.Close()
+		if err != nil {
+			section := pr.section()
+			if section == "" {
+				section = "root"
+			}
+			return fmt.Errorf("Scanner %v failed on part %v: %v", s.Name(), section, err)
+		}
+		result.Scanner = s.Name()
+		p.scans = append(p.scans, result)
+	}
+	return nil
+}
+
+// scanText runs the configured scanners over the body of a text only message, which has no
+// part of its own, attaching their results to m
+func (pr *parser) scanText(m *MIMEBody, header textproto.MIMEHeader, body []byte) error {
+	if len(pr.opts.Scanners) == 0 {
+		return nil
+	}
+	mediatype, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediatype = "text/plain"
+	}
+	p := &memMIMEPart{header: header, contentType: mediatype, content: body}
+	if err := pr.scan(p); err != nil {
+		return err
+	}
+	m.Scans = p.scans
+	return nil
+}
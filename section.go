@@ -0,0 +1,198 @@
+package enmime
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// IMAPSection is the result of resolving an IMAP section specifier (RFC 3501 section 6.4.5)
+// against a MIMEPart tree.
+type IMAPSection struct {
+	Part      MIMEPart // Part addressed by the section's part number, the root if none
+	Specifier string   // HEADER, HEADER.FIELDS, HEADER.FIELDS.NOT, TEXT, MIME or empty
+	Fields    []string // Header field names for HEADER.FIELDS and HEADER.FIELDS.NOT
+	header    textproto.MIMEHeader
+	content   []byte
+}
+
+// Header returns the header addressed by a HEADER, HEADER.FIELDS, HEADER.FIELDS.NOT or MIME
+// section, filtered by Fields where applicable.  It is nil for other sections.  The
+// Content-Type parameters the parser copies into the header are left out, as they are not
+// fields of the message.
+func (s *IMAPSection) Header() textproto.MIMEHeader {
+	if s.header == nil {
+		return nil
+	}
+	want := make(map[string]bool, len(s.Fields))
+	for _, f := range s.Fields {
+		want[textproto.CanonicalMIMEHeaderKey(f)] = true
+	}
+	h := make(textproto.MIMEHeader)
+	for k, v := range s.header {
+		if isParamField(s.header, k) {
+			continue
+		}
+		switch s.Specifier {
+		case "HEADER.FIELDS", "HEADER.FIELDS.NOT":
+			if want[textproto.CanonicalMIMEHeaderKey(k)] != (s.Specifier == "HEADER.FIELDS") {
+				continue
+			}
+		}
+		h[k] = v
+	}
+	return h
+}
+
+// Content returns the content addressed by a part number or TEXT section.  Message/rfc822
+// parts yield the encapsulated message.  Multipart parts are not retained in their encoded
+// form, so their content is nil.
+//
+// The content of a leaf part is decoded: its transfer encoding is removed, and it may have
+// been converted to UTF-8 or decompressed by the parser.  An IMAP BODY[n] response carries
+// the body as transfer encoded in the message, so a server must encode the content again
+// per the Content-Transfer-Encoding of the part, or serve it from the original message.
+func (s *IMAPSection) Content() []byte {
+	return s.content
+}
+
+// ResolveIMAPSection locates the part of the tree rooted at root that is addressed by the
+// IMAP section specifier, e.g. "1.2", "2.TEXT", "1.MIME" or "HEADER.FIELDS (From To)".  The
+// root is treated as the top-level message, and part numbers followed into message/rfc822
+// parts address the encapsulated message.
+func ResolveIMAPSection(root MIMEPart, section string) (*IMAPSection, error) {
+	spec := strings.TrimSpace(section)
+	var fields []string
+	if i := strings.Index(spec, "("); i >= 0 {
+		if !strings.HasSuffix(spec, ")") {
+			return nil, fmt.Errorf("Invalid header field list in section %q", section)
+		}
+		fields = strings.Fields(spec[i+1 : len(spec)-1])
+		spec = strings.TrimSpace(spec[:i])
+	}
+
+	// Walk part numbers, msg is the message whose body the next number indexes into
+	var tokens []string
+	if spec != "" {
+		tokens = strings.Split(spec, ".")
+	}
+	msg := root
+	var part MIMEPart
+	var partContent []byte
+	for len(tokens) > 0 {
+		n, err := strconv.Atoi(tokens[0])
+		if err != nil {
+			break
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("Invalid part number %v in section %q", n, section)
+		}
+		tokens = tokens[1:]
+		if part != nil {
+			// Descend into the message encapsulated by the previous part
+			if !isMessagePart(part) {
+				if part.FirstChild() == nil {
+					return nil, fmt.Errorf("No part %v in section %q", n, section)
+				}
+				msg = part
+			} else {
+				partContent = part.Content()
+				msg = parseEmbeddedMessage(partContent)
+			}
+		}
+		if part = childPart(msg, n); part == nil {
+			return nil, fmt.Errorf("No part %v in section %q", n, section)
+		}
+	}
+
+	s := &IMAPSection{Part: part, Specifier: strings.ToUpper(strings.Join(tokens, ".")),
+		Fields: fields}
+	if part == nil {
+		s.Part = root
+	}
+
+	// The message that HEADER and TEXT refer to is the root, or the one encapsulated by part
+	var target MIMEPart
+	if part == nil {
+		target = root
+	} else if isMessagePart(part) {
+		partContent = part.Content()
+		target = parseEmbeddedMessage(partContent)
+	}
+
+	switch s.Specifier {
+	case "":
+		if s.Part.FirstChild() == nil {
+			s.content = s.Part.Content()
+		}
+	case "HEADER", "HEADER.FIELDS", "HEADER.FIELDS.NOT":
+		if target == nil {
+			return nil, fmt.Errorf("Section %q does not address a message", section)
+		}
+		if (s.Specifier == "HEADER") != (fields == nil) {
+			return nil, fmt.Errorf("Invalid header field list in section %q", section)
+		}
+		s.header = target.Header()
+	case "TEXT":
+		if target == nil {
+			return nil, fmt.Errorf("Section %q does not address a message", section)
+		}
+		if part != nil {
+			s.content = messageText(partContent)
+		} else if root.FirstChild() == nil {
+			s.content = root.Content()
+		}
+	case "MIME":
+		if part == nil {
+			return nil, fmt.Errorf("MIME section %q requires a part number", section)
+		}
+		s.header = part.Header()
+	default:
+		return nil, fmt.Errorf("Unknown section specifier %q", s.Specifier)
+	}
+
+	return s, nil
+}
+
+// childPart returns part n (1 based) of the body of msg.  A non-multipart body only has
+// part 1, the body itself.
+func childPart(msg MIMEPart, n int) MIMEPart {
+	if !strings.HasPrefix(msg.ContentType(), "multipart/") {
+		if n == 1 {
+			return msg
+		}
+		return nil
+	}
+	c := msg.FirstChild()
+	for i := 1; c != nil && i < n; i++ {
+		c = c.NextSibling()
+	}
+	return c
+}
+
+// isMessagePart returns true if p encapsulates a message
+func isMessagePart(p MIMEPart) bool {
+	return strings.EqualFold(p.ContentType(), "message/rfc822")
+}
+
+// messageText returns the body of a raw message, following the blank line that ends its
+// header
+func messageText(raw []byte) []byte {
+	if bytes.HasPrefix(raw, []byte("\r\n")) {
+		return raw[2:]
+	}
+	if bytes.HasPrefix(raw, []byte("\n")) {
+		return raw[1:]
+	}
+	crlf := bytes.Index(raw, []byte("\r\n\r\n"))
+	lf := bytes.Index(raw, []byte("\n\n"))
+	switch {
+	case crlf >= 0 && (lf < 0 || crlf < lf):
+		return raw[crlf+4:]
+	case lf >= 0:
+		return raw[lf+2:]
+	}
+	return nil
+}
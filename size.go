@@ -0,0 +1,109 @@
+package enmime
+
+import (
+	"encoding/base64"
+	"mime"
+	"strings"
+)
+
+// boundaryLen is the length of the boundaries newBoundary generates
+const boundaryLen = len("enmime-") + 48
+
+// WireSize returns the exact number of bytes WriteMIME would write for the tree rooted at p,
+// without keeping the output.  Use it to check a message against a provider's size limit
+// before sending.
+func WireSize(p MIMEPart) (int64, error) {
+	w := &countingWriter{}
+	if err := WriteMIME(w, p); err != nil {
+		return 0, err
+	}
+	return w.n, nil
+}
+
+// EstimateWireSize approximates the size WireSize would return without encoding any content.
+// Base64 and unencoded content are sized exactly; quoted-printable is estimated from the
+// number of bytes needing escapes.  It is cheap enough to call after every change to a
+// message, such as when deciding whether to replace an attachment with a link.
+func EstimateWireSize(p MIMEPart) int64 {
+	var size int64
+	header := wireHeader(p)
+	for k, values := range header {
+		for _, v := range values {
+			size += int64(len(k) + len(": ") + len(v) + len("\r\n"))
+		}
+	}
+	size += 2
+
+	if strings.HasPrefix(p.ContentType(), "multipart/") {
+		// The Content-Type is rewritten with a new boundary
+		ctype := header.Get("Content-Type")
+		_, params, err := mime.ParseMediaType(ctype)
+		if err != nil {
+			params = make(map[string]string)
+		}
+		params["boundary"] = strings.Repeat("x", boundaryLen)
+		if ctype == "" {
+			size += int64(len("Content-Type: ") + 2)
+		}
+		size += int64(len(mime.FormatMediaType(p.ContentType(), params)) - len(ctype))
+		for c := p.FirstChild(); c != nil; c = c.NextSibling() {
+			size += int64(2+boundaryLen+2) + EstimateWireSize(c) + 2
+		}
+		return size + int64(2+boundaryLen+2+2)
+	}
+
+	if header.Get("Content-Type") == "" {
+		size += int64(len("Content-Type: ") + len(p.ContentType()) + 2)
+	}
+	content := p.Content()
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		n := base64.StdEncoding.EncodedLen(len(content))
+		size += int64(n + (n+75)/76*2)
+	case "quoted-printable":
+		size += estimateQP(content)
+	default:
+		size += int64(len(content))
+	}
+	return size
+}
+
+// estimateQP estimates the quoted-printable encoded size of content
+func estimateQP(content []byte) int64 {
+	var size, line int64
+	for i, c := range content {
+		n := int64(1)
+		switch {
+		case c == '\n':
+			if i == 0 || content[i-1] != '\r' {
+				size++ // Written as CRLF
+			}
+			size++
+			line = 0
+			continue
+		case c == '\r':
+			size++
+			continue
+		case c == '=' || c > '~' || (c < ' ' && c != '\t'):
+			n = 3
+		}
+		if line+n > 75 {
+			size += 3 // Soft line break
+			line = 0
+		}
+		size += n
+		line += n
+	}
+	return size
+}
+
+// countingWriter discards what is written to it, counting the bytes
+type countingWriter struct {
+	n int64
+}
+
+// Write method for io.Writer interface.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
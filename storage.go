@@ -0,0 +1,93 @@
+package enmime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Storage holds the decoded content of parts outside of memory, see ParseOptions.Storage.
+// FileStorage keeps it on disk; callers may implement Storage for a blob store.
+type Storage interface {
+	// Store reads the decoded content of p from r, returning the key Open will find it by.
+	// The part has its header, ContentType, Disposition, FileName and PartID.  An error fails
+	// the parse.
+	Store(p MIMEPart, r io.Reader) (key string, err error)
+	// Open returns the content stored under key
+	Open(key string) (io.ReadCloser, error)
+}
+
+// FileStorage is a Storage keeping each part in its own file in Dir, or the default
+// directory for temporary files if Dir is "".  The key is the path of the file.  Files are
+// not removed by this package; the caller removes them, or Dir, when the message is done.
+type FileStorage struct {
+	Dir string
+}
+
+// Store writes the content read from r to a new file
+func (s FileStorage) Store(p MIMEPart, r io.Reader) (string, error) {
+	f, err := os.CreateTemp(s.Dir, "enmime-part-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Open opens the file named by key
+func (s FileStorage) Open(key string) (io.ReadCloser, error) {
+	return os.Open(key)
+}
+
+// OpenContent returns a reader of the decoded content of p, reading it from storage rather
+// than loading it into memory if the part was parsed with ParseOptions.Storage.
+func OpenContent(p MIMEPart) (io.ReadCloser, error) {
+	if mp, ok := p.(*memMIMEPart); ok && mp.storage != nil {
+		return mp.storage.Open(mp.storageKey)
+	}
+	return io.NopCloser(bytes.NewReader(p.Content())), nil
+}
+
+// StorageKey returns the key the content of p was stored under by ParseOptions.Storage, or ""
+// if its content is held in memory.
+func StorageKey(p MIMEPart) string {
+	if mp, ok := p.(*memMIMEPart); ok && mp.storage != nil {
+		return mp.storageKey
+	}
+	return ""
+}
+
+// stores returns true if the content of p is to be streamed to storage.  Text is kept in
+// memory for the message body, and content that is examined or rewritten after decoding
+// cannot be streamed.
+func (pr *parser) stores(p *memMIMEPart) bool {
+	return pr.opts.Storage != nil && !pr.opts.Lenient &&
+		!strings.HasPrefix(p.contentType, "text/") &&
+		p.contentType != "application/applesingle" && p.contentType != "application/applefile"
+}
+
+// store decodes the content of p from reader straight into storage, then scans it
+func (pr *parser) store(p *memMIMEPart, reader io.Reader) error {
+	decoder, err := pr.contentReader(p.header, reader)
+	if err != nil {
+		return err
+	}
+	cr := &countingReader{r: decoder}
+	key, err := pr.opts.Storage.Store(p, cr)
+	if err != nil {
+		return fmt.Errorf("Unable to store part %v: %v", p.partID, err)
+	}
+	p.storage, p.storageKey = pr.opts.Storage, key
+	pr.contentDecoded(p.header, cr.bytes)
+	return pr.scan(p)
+}
@@ -0,0 +1,208 @@
+package enmime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// TNEF (application/ms-tnef, usually named winmail.dat) is how Outlook sends message
+// properties and attachments that plain MIME cannot carry.  Only as much is read as is
+// needed to get attachments and meeting invites out of it.
+
+// TNEF attribute levels and identifiers, with the attribute type in the high word
+const (
+	tnefSignature = 0x223E9F78
+
+	tnefLevelMessage    = 1
+	tnefLevelAttachment = 2
+
+	tnefAttMessageClass   = 0x00078008
+	tnefAttMsgProps       = 0x00069003
+	tnefAttAttachRendData = 0x00069002
+	tnefAttAttachTitle    = 0x00018010
+	tnefAttAttachData     = 0x0006800F
+	tnefAttAttachment     = 0x00069005
+
+	tnefMaxProps = 1 << 16 // Limit on the MAPI properties of one attribute
+)
+
+// tnefMessage is the content of a TNEF stream
+type tnefMessage struct {
+	class       string            // MAPI message class, such as "IPM.Schedule.Meeting.Request"
+	props       map[uint16][]byte // Values of the message level MAPI properties by ID
+	attachments []*tnefAttachment
+}
+
+// tnefAttachment is an attachment carried in a TNEF stream
+type tnefAttachment struct {
+	name     string
+	mimeType string
+	data     []byte
+}
+
+// IsTNEF returns true if data begins with the TNEF signature.
+func IsTNEF(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == tnefSignature
+}
+
+// parseTNEF reads the message class, message properties and attachments of a TNEF stream
+func parseTNEF(data []byte) (*tnefMessage, error) {
+	if !IsTNEF(data) || len(data) < 6 {
+		return nil, fmt.Errorf("Invalid TNEF signature")
+	}
+	le := binary.LittleEndian
+	m := &tnefMessage{}
+	var cur *tnefAttachment
+	for pos := 6; pos < len(data); {
+		if len(data)-pos < 9 {
+			return nil, fmt.Errorf("TNEF attribute header truncated at %v", pos)
+		}
+		level := data[pos]
+		id := le.Uint32(data[pos+1:])
+		n := int(le.Uint32(data[pos+5:]))
+		pos += 9
+		if n < 0 || n > len(data)-pos-2 {
+			return nil, fmt.Errorf("TNEF attribute %#x truncated", id)
+		}
+		value := data[pos : pos+n]
+		pos += n + 2 // Skip the checksum
+
+		switch {
+		case level == tnefLevelMessage && id == tnefAttMessageClass:
+			m.class = strings.TrimRight(string(value), "\x00")
+		case level == tnefLevelMessage && id == tnefAttMsgProps:
+			props, err := parseTNEFProps(value)
+			if err != nil {
+				return nil, err
+			}
+			if m.props == nil {
+				m.props = make(map[uint16][]byte)
+			}
+			for id, p := range props.props {
+				m.props[id] = p.data
+			}
+		case level == tnefLevelAttachment && id == tnefAttAttachRendData:
+			// Each attachment starts with its rendering information
+			cur = &tnefAttachment{}
+			m.attachments = append(m.attachments, cur)
+		case level == tnefLevelAttachment && cur != nil && id == tnefAttAttachTitle:
+			if cur.name == "" {
+				cur.name = strings.TrimRight(string(value), "\x00")
+			}
+		case level == tnefLevelAttachment && cur != nil && id == tnefAttAttachData:
+			cur.data = value
+		case level == tnefLevelAttachment && cur != nil && id == tnefAttAttachment:
+			props, err := parseTNEFProps(value)
+			if err != nil {
+				return nil, err
+			}
+			if name := props.str(msgPropAttachLongName); name != "" {
+				cur.name = name
+			}
+			cur.mimeType = props.str(msgPropAttachMimeTag)
+			if cur.data == nil {
+				cur.data = props.bin(msgPropAttachData)
+			}
+		}
+	}
+	return m, nil
+}
+
+// parseTNEFProps reads an encoded MAPI property list into a msgObject, keeping the first
+// value of each property
+func parseTNEFProps(data []byte) (*msgObject, error) {
+	o := &msgObject{props: make(map[uint16]msgProp)}
+	r := &tnefReader{data: data}
+	count := r.uint32()
+	if count > tnefMaxProps {
+		return nil, fmt.Errorf("TNEF property count %v exceeds limit", count)
+	}
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		typ, id := r.uint16(), r.uint16()
+		if id >= 0x8000 {
+			// Named property: a GUID, then an ID or a name
+			r.bytes(16)
+			if r.uint32() == 1 {
+				r.bytes(int(r.uint32()))
+				r.align()
+			} else {
+				r.uint32()
+			}
+		}
+		multi := typ&0x1000 != 0
+		typ &^= 0x1000
+
+		values := uint32(1)
+		if multi || typ == msgTypeString8 || typ == msgTypeUnicode || typ == msgTypeBinary ||
+			typ == msgTypeObject {
+			values = r.uint32()
+		}
+		for v := uint32(0); v < values && r.err == nil; v++ {
+			var value []byte
+			switch typ {
+			case msgTypeString8, msgTypeUnicode, msgTypeBinary, msgTypeObject:
+				value = r.bytes(int(r.uint32()))
+				r.align()
+			case 0x0005, 0x0006, 0x0007, msgTypeInt64, msgTypeSysTime:
+				value = r.bytes(8)
+			case 0x0048:
+				value = r.bytes(16)
+			default:
+				// PT_SHORT, PT_LONG, PT_FLOAT, PT_ERROR and PT_BOOLEAN are padded to 4 bytes
+				value = r.bytes(4)
+			}
+			if _, ok := o.props[id]; !ok && v == 0 {
+				o.props[id] = msgProp{typ: typ, data: value}
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return o, nil
+}
+
+// tnefReader reads little-endian values from a buffer, recording the first overrun
+type tnefReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// bytes returns the next n bytes
+func (r *tnefReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.pos {
+		r.err = fmt.Errorf("TNEF property list truncated at %v", r.pos)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// uint16 returns the next 16 bit value
+func (r *tnefReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+// uint32 returns the next 32 bit value
+func (r *tnefReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// align skips the padding to the next multiple of 4 bytes
+func (r *tnefReader) align() {
+	if pad := (4 - r.pos%4) % 4; pad > 0 && r.pos+pad <= len(r.data) {
+		r.pos += pad
+	}
+}
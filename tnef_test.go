@@ -0,0 +1,202 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// tnefStream builds a TNEF stream from its attributes
+type tnefStream struct {
+	bytes.Buffer
+}
+
+// newTNEFStream starts a TNEF stream with its signature and key
+func newTNEFStream() *tnefStream {
+	s := &tnefStream{}
+	binary.Write(s, binary.LittleEndian, uint32(tnefSignature))
+	s.Write([]byte{1, 0})
+	return s
+}
+
+// attr appends an attribute with a zero checksum, which is not verified
+func (s *tnefStream) attr(level byte, id uint32, data []byte) *tnefStream {
+	s.WriteByte(level)
+	binary.Write(s, binary.LittleEndian, id)
+	binary.Write(s, binary.LittleEndian, uint32(len(data)))
+	s.Write(data)
+	s.Write([]byte{0, 0})
+	return s
+}
+
+// tnefProps encodes a MAPI property list from PT_LONG and PT_UNICODE properties
+func tnefProps(longs map[uint16]uint32, strs map[uint16]string) []byte {
+	le := binary.LittleEndian
+	var b bytes.Buffer
+	binary.Write(&b, le, uint32(len(longs)+len(strs)))
+	for id, v := range longs {
+		binary.Write(&b, le, []uint16{msgTypeLong, id})
+		binary.Write(&b, le, v)
+	}
+	for id, s := range strs {
+		u := utf16.Encode([]rune(s + "\x00"))
+		binary.Write(&b, le, []uint16{msgTypeUnicode, id})
+		binary.Write(&b, le, []uint32{1, uint32(2 * len(u))})
+		binary.Write(&b, le, u)
+		for b.Len()%4 != 0 {
+			b.WriteByte(0)
+		}
+	}
+	return b.Bytes()
+}
+
+func TestParseTNEF(t *testing.T) {
+	data := newTNEFStream().
+		attr(tnefLevelMessage, tnefAttMessageClass, []byte("IPM.Note\x00")).
+		attr(tnefLevelMessage, tnefAttMsgProps, tnefProps(map[uint16]uint32{0x0017: 1}, nil)).
+		attr(tnefLevelAttachment, tnefAttAttachRendData, make([]byte, 14)).
+		attr(tnefLevelAttachment, tnefAttAttachTitle, []byte("REPORT~1.PDF\x00")).
+		attr(tnefLevelAttachment, tnefAttAttachData, []byte("%PDF")).
+		attr(tnefLevelAttachment, tnefAttAttachment, tnefProps(nil, map[uint16]string{
+			msgPropAttachLongName: "report.pdf",
+			msgPropAttachMimeTag:  "application/pdf",
+		})).
+		attr(tnefLevelAttachment, tnefAttAttachRendData, make([]byte, 14)).
+		attr(tnefLevelAttachment, tnefAttAttachTitle, []byte("notes.txt\x00")).
+		attr(tnefLevelAttachment, tnefAttAttachData, []byte("notes")).
+		Bytes()
+	if !IsTNEF(data) {
+		t.Fatal("IsTNEF() = false")
+	}
+	m, err := parseTNEF(data)
+	if err != nil {
+		t.Fatalf("parseTNEF() error: %v", err)
+	}
+	if m.class != "IPM.Note" {
+		t.Errorf("class = %q, want \"IPM.Note\"", m.class)
+	}
+	if p := m.props[0x0017]; len(p) != 4 || binary.LittleEndian.Uint32(p) != 1 {
+		t.Errorf("props[0x0017] = %v, want 1", p)
+	}
+	want := []tnefAttachment{
+		{name: "report.pdf", mimeType: "application/pdf", data: []byte("%PDF")},
+		{name: "notes.txt", data: []byte("notes")},
+	}
+	if len(m.attachments) != len(want) {
+		t.Fatalf("got %v attachments, want %v", len(m.attachments), len(want))
+	}
+	for i, a := range m.attachments {
+		if a.name != want[i].name || a.mimeType != want[i].mimeType ||
+			!bytes.Equal(a.data, want[i].data) {
+			t.Errorf("attachment %v = %q %q %q, want %q %q %q", i, a.name, a.mimeType, a.data,
+				want[i].name, want[i].mimeType, want[i].data)
+		}
+	}
+}
+
+func TestParseTNEFInvalid(t *testing.T) {
+	valid := newTNEFStream().
+		attr(tnefLevelMessage, tnefAttMessageClass, []byte("IPM.Note\x00")).
+		Bytes()
+	tooMany := make([]byte, 4)
+	binary.LittleEndian.PutUint32(tooMany, tnefMaxProps+1)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"signature", []byte("\x78\x9f\x3e\x22")},
+		{"not TNEF", []byte("This is not TNEF")},
+		{"truncated attribute header", valid[:10]},
+		{"truncated attribute", valid[:len(valid)-3]},
+		{"truncated properties", newTNEFStream().
+			attr(tnefLevelMessage, tnefAttMsgProps, tnefProps(nil, map[uint16]string{
+				msgPropAttachLongName: "report.pdf",
+			})[:12]).Bytes()},
+		{"too many properties", newTNEFStream().
+			attr(tnefLevelMessage, tnefAttMsgProps, tooMany).Bytes()},
+	}
+	for _, tt := range tests {
+		if _, err := parseTNEF(tt.data); err == nil {
+			t.Errorf("%v: parseTNEF() returned no error", tt.name)
+		}
+	}
+}
+
+func TestFindInviteTNEF(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\nMETHOD:REQUEST\r\nBEGIN:VEVENT\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	tests := []struct {
+		name       string
+		data       []byte
+		wantMethod string
+		wantData   bool
+	}{
+		{
+			name: "iCalendar attachment",
+			data: newTNEFStream().
+				attr(tnefLevelMessage, tnefAttMessageClass, []byte("IPM.Note\x00")).
+				attr(tnefLevelAttachment, tnefAttAttachRendData, make([]byte, 14)).
+				attr(tnefLevelAttachment, tnefAttAttachTitle, []byte("invite.ics\x00")).
+				attr(tnefLevelAttachment, tnefAttAttachData, []byte(ics)).
+				Bytes(),
+			wantMethod: "REQUEST",
+			wantData:   true,
+		},
+		{
+			name: "meeting request class",
+			data: newTNEFStream().
+				attr(tnefLevelMessage, tnefAttMessageClass,
+					[]byte("IPM.Schedule.Meeting.Request\x00")).
+				Bytes(),
+			wantMethod: "REQUEST",
+		},
+		{
+			name: "meeting cancellation class",
+			data: newTNEFStream().
+				attr(tnefLevelMessage, tnefAttMessageClass,
+					[]byte("IPM.Schedule.Meeting.Canceled\x00")).
+				Bytes(),
+			wantMethod: "CANCEL",
+		},
+		{
+			name: "meeting response class",
+			data: newTNEFStream().
+				attr(tnefLevelMessage, tnefAttMessageClass,
+					[]byte("IPM.Schedule.Meeting.Resp.Pos\x00")).
+				Bytes(),
+			wantMethod: "REPLY",
+		},
+		{
+			name: "plain message",
+			data: newTNEFStream().
+				attr(tnefLevelMessage, tnefAttMessageClass, []byte("IPM.Note\x00")).
+				Bytes(),
+		},
+	}
+	for _, tt := range tests {
+		root := NewMIMEPart(nil, "multipart/mixed")
+		p := NewMIMEPart(nil, "application/ms-tnef")
+		p.content = tt.data
+		appendChild(root, p)
+		inv := FindInvite(root)
+		if tt.wantMethod == "" {
+			if inv != nil {
+				t.Errorf("%v: FindInvite() = %+v, want nil", tt.name, inv)
+			}
+			continue
+		}
+		if inv == nil {
+			t.Errorf("%v: FindInvite() = nil", tt.name)
+			continue
+		}
+		if inv.Source != InviteTNEF || inv.Method != tt.wantMethod || inv.Part != p {
+			t.Errorf("%v: FindInvite() = %v %v, want %v %v", tt.name, inv.Source, inv.Method,
+				InviteTNEF, tt.wantMethod)
+		}
+		if (inv.Data != nil) != tt.wantData {
+			t.Errorf("%v: FindInvite() Data = %q", tt.name, inv.Data)
+		}
+	}
+}
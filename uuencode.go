@@ -0,0 +1,235 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pre-MIME mail carried binary files inside of the plain text body, either uuencoded or as
+// BinHex 4.0 blocks.
+
+var (
+	uuBeginRe       = regexp.MustCompile(`^begin [0-7]{3,4} (.+)$`)
+	uuBase64BeginRe = regexp.MustCompile(`^begin-base64 [0-7]{3,4} (.+)$`)
+)
+
+const (
+	binhexIntro    = "(This file must be converted with BinHex"
+	binhexAlphabet = "!\"#$%&'()*+,-012345689@ABCDEFGHIJKLMNPQRSTUVXYZ[`abcdefhijklmpqr"
+)
+
+// ExtractEncodedBlocks finds uuencoded and BinHex 4.0 blocks in a plain text body, decodes
+// each into an attachment MIMEPart, and returns the text with those blocks removed.  Blocks
+// that fail to decode are left in the text.
+func ExtractEncodedBlocks(text string) (string, []MIMEPart) {
+	lines := strings.SplitAfter(text, "\n")
+	var out strings.Builder
+	var parts []MIMEPart
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		var part *memMIMEPart
+		var end int
+		switch {
+		case uuBeginRe.MatchString(line):
+			name := uuBeginRe.FindStringSubmatch(line)[1]
+			part, end = decodeUUBlock(lines, i+1, name, false)
+		case uuBase64BeginRe.MatchString(line):
+			name := uuBase64BeginRe.FindStringSubmatch(line)[1]
+			part, end = decodeUUBlock(lines, i+1, name, true)
+		case strings.HasPrefix(line, binhexIntro):
+			part, end = decodeBinHexBlock(lines, i+1)
+		}
+		if part == nil {
+			out.WriteString(lines[i])
+			continue
+		}
+		parts = append(parts, part)
+		i = end
+	}
+	return out.String(), parts
+}
+
+// decodeUUBlock decodes the uuencoded lines beginning at start, returning the attachment
+// and the index of the block's end line, or nil if the block is invalid.
+func decodeUUBlock(lines []string, start int, name string, isBase64 bool) (*memMIMEPart, int) {
+	var data, b64 bytes.Buffer
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		if isBase64 {
+			if line == "====" {
+				content, err := base64.StdEncoding.DecodeString(b64.String())
+				if err != nil {
+					return nil, 0
+				}
+				return NewAttachmentPart(content, DispositionParams{FileName: name}), i
+			}
+			b64.WriteString(strings.TrimSpace(line))
+			continue
+		}
+		if line == "end" {
+			return NewAttachmentPart(data.Bytes(), DispositionParams{FileName: name}), i
+		}
+		decoded, err := decodeUULine(line)
+		if err != nil {
+			return nil, 0
+		}
+		data.Write(decoded)
+	}
+	return nil, 0
+}
+
+// decodeUULine decodes a single line of uuencoded data
+func decodeUULine(line string) ([]byte, error) {
+	if line == "" {
+		return nil, nil
+	}
+	n := int(line[0]-' ') & 63
+	var out []byte
+	for i := 1; len(out) < n; i += 4 {
+		var quad [4]byte
+		for j := range quad {
+			c := byte('`')
+			if i+j < len(line) {
+				// Some encoders strip trailing spaces, so missing characters are zero
+				c = line[i+j]
+			}
+			if c < ' ' || c > '`' {
+				return nil, fmt.Errorf("Invalid uuencoded character %q", c)
+			}
+			quad[j] = (c - ' ') & 63
+		}
+		out = append(out, quad[0]<<2|quad[1]>>4, quad[1]<<4|quad[2]>>2, quad[2]<<6|quad[3])
+	}
+	return out[:n], nil
+}
+
+// decodeBinHexBlock decodes the BinHex 4.0 data following its introductory line, returning
+// the data fork as an attachment and the index of the block's last line, or nil if the block
+// is invalid.
+func decodeBinHexBlock(lines []string, start int) (*memMIMEPart, int) {
+	// Find the data between the opening and closing colons
+	var encoded strings.Builder
+	opened := false
+	end := -1
+	for i := start; i < len(lines) && end < 0; i++ {
+		line := strings.TrimSpace(lines[i])
+		if !opened {
+			if line == "" {
+				continue
+			}
+			if line[0] != ':' {
+				return nil, 0
+			}
+			opened = true
+			line = line[1:]
+		}
+		if j := strings.IndexByte(line, ':'); j >= 0 {
+			line = line[:j]
+			end = i
+		}
+		encoded.WriteString(line)
+	}
+	if end < 0 {
+		return nil, 0
+	}
+	raw, err := decodeBinHex(encoded.String())
+	if err != nil {
+		return nil, 0
+	}
+	name, data, err := parseBinHex(raw)
+	if err != nil {
+		return nil, 0
+	}
+	return NewAttachmentPart(data, DispositionParams{FileName: name}), end
+}
+
+// decodeBinHex converts BinHex 4.0 characters to bytes and expands run length encoding
+func decodeBinHex(encoded string) ([]byte, error) {
+	var packed []byte
+	var acc uint32
+	bits := 0
+	for i := 0; i < len(encoded); i++ {
+		v := strings.IndexByte(binhexAlphabet, encoded[i])
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid BinHex character %q", encoded[i])
+		}
+		acc = acc<<6 | uint32(v)
+		bits += 6
+		if bits >= 8 {
+			bits -= 8
+			packed = append(packed, byte(acc>>uint(bits)))
+		}
+	}
+
+	// 0x90 n repeats the previous byte n times in total, 0x90 0 is a literal 0x90
+	out := make([]byte, 0, len(packed))
+	for i := 0; i < len(packed); i++ {
+		if packed[i] != 0x90 {
+			out = append(out, packed[i])
+			continue
+		}
+		if i+1 >= len(packed) {
+			return nil, fmt.Errorf("Truncated BinHex run length")
+		}
+		i++
+		n := int(packed[i])
+		if n == 0 {
+			out = append(out, 0x90)
+			continue
+		}
+		if len(out) == 0 {
+			return nil, fmt.Errorf("BinHex run length without preceding byte")
+		}
+		prev := out[len(out)-1]
+		for ; n > 1; n-- {
+			out = append(out, prev)
+		}
+	}
+	return out, nil
+}
+
+// parseBinHex verifies the header and data fork checksums of decoded BinHex data and
+// returns the file name and data fork
+func parseBinHex(b []byte) (string, []byte, error) {
+	if len(b) < 1 {
+		return "", nil, fmt.Errorf("Truncated BinHex header")
+	}
+	nameLen := int(b[0])
+	hdrLen := 1 + nameLen + 1 + 4 + 4 + 2 + 4 + 4
+	if len(b) < hdrLen+2 {
+		return "", nil, fmt.Errorf("Truncated BinHex header")
+	}
+	if crc := binary.BigEndian.Uint16(b[hdrLen:]); crc != binhexCRC(b[:hdrLen]) {
+		return "", nil, fmt.Errorf("BinHex header checksum mismatch")
+	}
+	name := string(b[1 : 1+nameLen])
+	dataLen := int(binary.BigEndian.Uint32(b[hdrLen-8:]))
+	data := b[hdrLen+2:]
+	if dataLen < 0 || len(data) < dataLen+2 {
+		return "", nil, fmt.Errorf("Truncated BinHex data fork")
+	}
+	if crc := binary.BigEndian.Uint16(data[dataLen:]); crc != binhexCRC(data[:dataLen]) {
+		return "", nil, fmt.Errorf("BinHex data fork checksum mismatch")
+	}
+	return name, data[:dataLen], nil
+}
+
+// binhexCRC computes the CRC-CCITT (XMODEM) checksum used by BinHex
+func binhexCRC(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,212 @@
+package enmime
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// uuencode returns data as uuencoded lines, without the begin and end lines
+func uuencode(data []byte) string {
+	enc := func(b byte) byte {
+		if b == 0 {
+			return '`'
+		}
+		return b + ' '
+	}
+	var out strings.Builder
+	for len(data) > 0 {
+		n := len(data)
+		if n > 45 {
+			n = 45
+		}
+		line := append([]byte(nil), data[:n]...)
+		data = data[n:]
+		for len(line)%3 != 0 {
+			line = append(line, 0)
+		}
+		out.WriteByte(enc(byte(n)))
+		for i := 0; i < len(line); i += 3 {
+			a, b, c := line[i], line[i+1], line[i+2]
+			out.WriteByte(enc(a >> 2))
+			out.WriteByte(enc((a<<4 | b>>4) & 63))
+			out.WriteByte(enc((b<<2 | c>>6) & 63))
+			out.WriteByte(enc(c & 63))
+		}
+		out.WriteString("\r\n")
+	}
+	return out.String() + "`\r\n"
+}
+
+// binhex returns a BinHex 4.0 block holding data as the data fork of a file named name,
+// including its colons but not the introductory line.  If badCRC is set the data fork
+// checksum is wrong.
+func binhex(name string, data []byte, badCRC bool) string {
+	be := binary.BigEndian
+	h := []byte{byte(len(name))}
+	h = append(h, name...)
+	h = append(h, 0)
+	h = append(h, "TEXTttxt"...)
+	h = append(h, 0, 0)
+	h = be.AppendUint32(h, uint32(len(data)))
+	h = be.AppendUint32(h, 0)
+	h = be.AppendUint16(h, binhexCRC(h))
+	h = append(h, data...)
+	crc := binhexCRC(data)
+	if badCRC {
+		crc++
+	}
+	h = be.AppendUint16(h, crc)
+	h = be.AppendUint16(h, binhexCRC(nil))
+
+	// Escape literal 0x90 bytes rather than compressing runs
+	var rle []byte
+	for _, b := range h {
+		rle = append(rle, b)
+		if b == 0x90 {
+			rle = append(rle, 0)
+		}
+	}
+	s := binhexChars(rle)
+	var lines []string
+	for len(s) > 64 {
+		lines = append(lines, s[:64])
+		s = s[64:]
+	}
+	lines = append(lines, s)
+	return ":" + strings.Join(lines, "\r\n") + ":\r\n"
+}
+
+// binhexChars encodes run length encoded bytes with the BinHex alphabet, six bits per
+// character
+func binhexChars(packed []byte) string {
+	var enc strings.Builder
+	var acc uint32
+	bits := 0
+	for _, b := range packed {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 6 {
+			bits -= 6
+			enc.WriteByte(binhexAlphabet[(acc>>uint(bits))&63])
+		}
+	}
+	if bits > 0 {
+		enc.WriteByte(binhexAlphabet[(acc<<uint(6-bits))&63])
+	}
+	return enc.String()
+}
+
+func TestExtractEncodedBlocks(t *testing.T) {
+	long := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 4))
+	intro := binhexIntro + " 4.0)\r\n"
+	tests := []struct {
+		name      string
+		text      string
+		wantText  string
+		wantFiles []string // Name and content of each extracted part
+	}{
+		{
+			name:     "no blocks",
+			text:     "Hello\r\nbegin here\r\n",
+			wantText: "Hello\r\nbegin here\r\n",
+		},
+		{
+			name:      "uuencode",
+			text:      "Hello\r\nbegin 644 fox.txt\r\n" + uuencode(long) + "end\r\nBye\r\n",
+			wantText:  "Hello\r\nBye\r\n",
+			wantFiles: []string{"fox.txt", string(long)},
+		},
+		{
+			name:      "uuencode base64",
+			text:      "begin-base64 644 hi.txt\r\naGVsbG8=\r\n====\r\n",
+			wantFiles: []string{"hi.txt", "hello"},
+		},
+		{
+			name:      "binhex",
+			text:      "Hello\r\n" + intro + binhex("a.gif", []byte("GIF89a\x90\x90data"), false) + "Bye",
+			wantText:  "Hello\r\nBye",
+			wantFiles: []string{"a.gif", "GIF89a\x90\x90data"},
+		},
+		{
+			name: "two blocks",
+			text: "begin 644 a.txt\r\n" + uuencode([]byte("a")) + "end\r\n" +
+				intro + binhex("b.txt", []byte("b"), false),
+			wantFiles: []string{"a.txt", "a", "b.txt", "b"},
+		},
+		{
+			name:     "uuencode without end",
+			text:     "begin 644 fox.txt\r\n" + uuencode(long),
+			wantText: "begin 644 fox.txt\r\n" + uuencode(long),
+		},
+		{
+			name:     "uuencode invalid character",
+			text:     "begin 644 x\r\n#~~~~\r\nend\r\n",
+			wantText: "begin 644 x\r\n#~~~~\r\nend\r\n",
+		},
+		{
+			name:     "uuencode base64 without end",
+			text:     "begin-base64 644 hi.txt\r\naGVsbG8=\r\n",
+			wantText: "begin-base64 644 hi.txt\r\naGVsbG8=\r\n",
+		},
+		{
+			name:     "binhex without closing colon",
+			text:     intro + strings.TrimSuffix(binhex("a", []byte("a"), false), ":\r\n"),
+			wantText: intro + strings.TrimSuffix(binhex("a", []byte("a"), false), ":\r\n"),
+		},
+		{
+			name:     "binhex truncated",
+			text:     intro + ":" + binhex("a", long, false)[1:20] + ":",
+			wantText: intro + ":" + binhex("a", long, false)[1:20] + ":",
+		},
+		{
+			name:     "binhex checksum",
+			text:     intro + binhex("a", []byte("a"), true),
+			wantText: intro + binhex("a", []byte("a"), true),
+		},
+	}
+	for _, tt := range tests {
+		text, parts := ExtractEncodedBlocks(tt.text)
+		if text != tt.wantText {
+			t.Errorf("%v: got text %q, want %q", tt.name, text, tt.wantText)
+		}
+		var files []string
+		for _, p := range parts {
+			files = append(files, p.FileName(), string(p.Content()))
+			if p.Disposition() != "attachment" {
+				t.Errorf("%v: %q has disposition %q", tt.name, p.FileName(), p.Disposition())
+			}
+		}
+		if strings.Join(files, "|") != strings.Join(tt.wantFiles, "|") {
+			t.Errorf("%v: got files %q, want %q", tt.name, files, tt.wantFiles)
+		}
+	}
+}
+
+func TestDecodeBinHexRuns(t *testing.T) {
+	tests := []struct {
+		name    string
+		packed  []byte
+		want    string
+		wantErr bool
+	}{
+		{"literal", []byte("abc"), "abc", false},
+		{"run", []byte{'a', 0x90, 4}, "aaaa", false},
+		{"escaped marker", []byte{'a', 0x90, 0}, "a\x90", false},
+		{"truncated run", []byte{'a', 0x90}, "", true},
+		{"run without byte", []byte{0x90, 3}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := decodeBinHex(binhexChars(tt.packed))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: decodeBinHex() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && string(got) != tt.want {
+			t.Errorf("%v: decodeBinHex() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := decodeBinHex("a~"); err == nil {
+		t.Error("decodeBinHex() accepted a character outside the alphabet")
+	}
+}
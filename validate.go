@@ -0,0 +1,259 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ViolationRule identifies the requirement a message breaks.
+type ViolationRule string
+
+const (
+	// RuleLineLength means a line exceeds 998 characters, RFC 5322 section 2.1.1
+	RuleLineLength ViolationRule = "line-length"
+	// RuleBareLineBreak means a line ends in a lone LF or CR rather than CRLF, RFC 5322
+	// section 2.3
+	RuleBareLineBreak ViolationRule = "bare-line-break"
+	// RuleMissingField means the message has no Date or no From field, RFC 5322 section 3.6
+	RuleMissingField ViolationRule = "missing-field"
+	// RuleRepeatedField means a field allowed once, such as Subject, appears more than once,
+	// RFC 5322 section 3.6
+	RuleRepeatedField ViolationRule = "repeated-field"
+	// Rule8BitData means a part with a 7bit transfer encoding, the default, has bytes above
+	// 127, RFC 2045 section 6.2
+	Rule8BitData ViolationRule = "8bit-data"
+	// RuleBoundary means a multipart boundary is empty, over 70 characters, ends in a space or
+	// uses characters outside bchars, RFC 2046 section 5.1.1
+	RuleBoundary ViolationRule = "boundary"
+)
+
+// maxLineLength is the RFC 5322 limit on a line, excluding the CRLF
+const maxLineLength = 998
+
+// singleFields may appear at most once in a message header, RFC 5322 section 3.6
+var singleFields = []string{"Date", "From", "Sender", "Reply-To", "To", "Cc", "Bcc",
+	"Message-Id", "In-Reply-To", "References", "Subject"}
+
+// Violation is a way in which a message fails to comply with RFC 5322 or RFC 2045.
+type Violation struct {
+	Rule    ViolationRule
+	PartID  string // Part the violation is in, "" for the message header
+	Line    int    // Line of the message, counting from 1, only set by ValidateMessage
+	Message string // Description of the problem
+}
+
+// String formats the violation for logging
+func (v Violation) String() string {
+	switch {
+	case v.Line > 0:
+		return fmt.Sprintf("%v: line %v: %v", v.Rule, v.Line, v.Message)
+	case v.PartID != "":
+		return fmt.Sprintf("%v: part %v: %v", v.Rule, v.PartID, v.Message)
+	}
+	return fmt.Sprintf("%v: %v", v.Rule, v.Message)
+}
+
+// Validate checks the message tree rooted at root for violations of RFC 5322 and RFC 2045,
+// such as before sending it with WriteMIME.  Content is checked as it would be written, after
+// any charset conversion or decompression made by the parser; ValidateMessage checks a message
+// as it was received.  Lines are checked in the content of parts WriteMIME writes without
+// encoding.  Violations are reported rather than corrected, an empty result means none were
+// found.
+func Validate(root MIMEPart) []Violation {
+	v := validateHeader(root)
+	return append(v, validateParts(root)...)
+}
+
+// ValidateMessage is like Validate for a message read from r, checking the input as it is
+// rather than its decoded content: the length and line breaks of every line, and the bytes of
+// each part before charset conversion or decompression.  An error is returned only if the
+// message cannot be parsed at all.
+func ValidateMessage(r io.Reader) ([]Violation, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := ParseMIMEWithOptions(bufio.NewReader(bytes.NewReader(raw)),
+		&ParseOptions{Lenient: true})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse message: %v", err)
+	}
+	br := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse message: %v", err)
+	}
+	v := validateHeader(root)
+	long, bare := checkLines(raw)
+	for _, n := range long {
+		v = append(v, Violation{Rule: RuleLineLength, Line: n,
+			Message: fmt.Sprintf("Line exceeds %v characters", maxLineLength)})
+	}
+	for _, n := range bare {
+		v = append(v, Violation{Rule: RuleBareLineBreak, Line: n,
+			Message: "Line does not end in CRLF"})
+	}
+	return append(v, validateRaw(header, br, "")...), nil
+}
+
+// validateHeader checks the fields of the message header
+func validateHeader(root MIMEPart) []Violation {
+	var v []Violation
+	header := root.Header()
+	for _, k := range []string{"Date", "From"} {
+		if len(header.Values(k)) == 0 {
+			v = append(v, Violation{Rule: RuleMissingField,
+				Message: fmt.Sprintf("Required %v field is missing", k)})
+		}
+	}
+	for _, k := range singleFields {
+		if n := len(header.Values(k)); n > 1 {
+			v = append(v, Violation{Rule: RuleRepeatedField,
+				Message: fmt.Sprintf("%v field appears %v times", k, n)})
+		}
+	}
+	return v
+}
+
+// validateParts checks the boundaries, content, and line lengths and breaks of the parts
+// beneath root
+func validateParts(root MIMEPart) []Violation {
+	var v []Violation
+	DepthMatchAll(root, func(p MIMEPart) bool {
+		if strings.HasPrefix(p.ContentType(), "multipart/") {
+			if ctype := p.Header().Get("Content-Type"); ctype != "" {
+				v = append(v, boundaryViolations(ctype, p.PartID())...)
+			}
+			return false
+		}
+
+		encoding := strings.ToLower(p.Header().Get("Content-Transfer-Encoding"))
+		if encoding == "base64" || encoding == "quoted-printable" {
+			return false
+		}
+		content := p.Content()
+		if p.Error() != nil {
+			content = p.RawContent()
+		}
+		v = append(v, contentViolations(encoding, content, p.PartID())...)
+		if encoding == "binary" {
+			return false
+		}
+		long, bare := checkLines(content)
+		if len(long) > 0 {
+			v = append(v, Violation{Rule: RuleLineLength, PartID: p.PartID(),
+				Message: fmt.Sprintf("%v lines exceed %v characters, the first is line %v",
+					len(long), maxLineLength, long[0])})
+		}
+		if len(bare) > 0 {
+			v = append(v, Violation{Rule: RuleBareLineBreak, PartID: p.PartID(),
+				Message: fmt.Sprintf("%v lines do not end in CRLF, the first is line %v",
+					len(bare), bare[0])})
+		}
+		return false
+	})
+	return v
+}
+
+// validateRaw checks the boundaries of the multiparts in the entity with header and body, and
+// the undecoded content of its leaf parts
+func validateRaw(header textproto.MIMEHeader, body io.Reader, partID string) []Violation {
+	ctype := header.Get("Content-Type")
+	mediatype, params, err := mime.ParseMediaType(ctype)
+	if err == nil && strings.HasPrefix(mediatype, "multipart/") {
+		v := boundaryViolations(ctype, partID)
+		if params["boundary"] == "" {
+			return v
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for n := 1; ; n++ {
+			p, err := mr.NextRawPart()
+			if err != nil {
+				// The end of the multipart, or a malformed one the lenient parse worked around
+				return v
+			}
+			id := strconv.Itoa(n)
+			if partID != "" {
+				id = partID + "." + id
+			}
+			v = append(v, validateRaw(p.Header, p, id)...)
+		}
+	}
+
+	encoding := strings.ToLower(header.Get("Content-Transfer-Encoding"))
+	if encoding == "base64" || encoding == "quoted-printable" {
+		return nil
+	}
+	content, _ := io.ReadAll(body)
+	return contentViolations(encoding, content, partID)
+}
+
+// boundaryViolations checks the boundary of a multipart with the Content-Type ctype
+func boundaryViolations(ctype, partID string) []Violation {
+	_, params, _ := mime.ParseMediaType(ctype)
+	if msg := checkBoundary(params["boundary"]); msg != "" {
+		return []Violation{{Rule: RuleBoundary, PartID: partID, Message: msg}}
+	}
+	return nil
+}
+
+// contentViolations checks that content is 7-bit if its transfer encoding requires it
+func contentViolations(encoding string, content []byte, partID string) []Violation {
+	if (encoding == "" || encoding == "7bit") && !isASCII(content) {
+		return []Violation{{Rule: Rule8BitData, PartID: partID,
+			Message: "Content has 8-bit data without an 8bit or binary transfer encoding"}}
+	}
+	return nil
+}
+
+// checkLines returns the numbers of the lines in data that are too long and that end in a
+// lone LF or CR
+func checkLines(data []byte) (long, bare []int) {
+	line, start := 1, 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c != '\r' && c != '\n' {
+			continue
+		}
+		if i-start > maxLineLength {
+			long = append(long, line)
+		}
+		if c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			i++
+		} else {
+			bare = append(bare, line)
+		}
+		line++
+		start = i + 1
+	}
+	if len(data)-start > maxLineLength {
+		long = append(long, line)
+	}
+	return long, bare
+}
+
+// checkBoundary returns why boundary is invalid, or "" if it is valid
+func checkBoundary(boundary string) string {
+	switch {
+	case boundary == "":
+		return "Boundary is empty"
+	case len(boundary) > 70:
+		return fmt.Sprintf("Boundary is %v characters, the limit is 70", len(boundary))
+	case strings.HasSuffix(boundary, " "):
+		return "Boundary ends in a space"
+	}
+	for _, c := range boundary {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+			strings.ContainsRune("'()+_,-./:=? ", c)) {
+			return fmt.Sprintf("Boundary has invalid character %q", c)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package enmime
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MessageView is a message arranged for display, with every field ready to be rendered by a
+// template such as html/template.  HTML is the body as sent and must be sanitized before it is
+// marked safe for a template.
+type MessageView struct {
+	Subject     string // Decoded Subject
+	Date        time.Time
+	From        AddressList
+	ReplyTo     AddressList
+	To          AddressList
+	Cc          AddressList
+	Text        string // Plain text body
+	HTML        string // HTML body with cid: references resolved, "" if there is none
+	Attachments []AttachmentView
+}
+
+// AddressView is a decoded address of an address list field.
+type AddressView struct {
+	Name    string // Display name, "" if there is none
+	Address string // Address, "" if the field could not be parsed and Name holds its text
+}
+
+// String formats the address as "Name <address>", without the encoding a header needs.
+func (a AddressView) String() string {
+	switch {
+	case a.Address == "":
+		return a.Name
+	case a.Name == "":
+		return a.Address
+	}
+	return a.Name + " <" + a.Address + ">"
+}
+
+// AddressList is the addresses of an address list field.
+type AddressList []AddressView
+
+// String formats the list as its addresses separated by commas.
+func (l AddressList) String() string {
+	s := make([]string, len(l))
+	for i, a := range l {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// AttachmentView describes a file attached to a message.
+type AttachmentView struct {
+	Name        string // Sanitized file name, unique within the message, see MIMEBody.Open
+	ContentType string
+	Size        int64  // Size of the decoded content in bytes
+	DisplaySize string // Size for display, such as "12 KB"
+	URL         string // URL returned by ViewOptions.PartURL, "" if it is not set
+	Part        MIMEPart
+}
+
+// ViewOptions controls the construction of a MessageView.
+type ViewOptions struct {
+	// PartURL returns the URL of a handler serving the content of p, used for attachments and
+	// for the parts the HTML body refers to by cid:.  If it is nil, cid: references are
+	// replaced with data: URLs holding the content.
+	PartURL func(p MIMEPart) string
+}
+
+// View returns the message arranged for display.  Attachments are listed as MIMEBody.Open
+// names them, leaving out the inline parts the HTML body shows through cid: references.
+func (m *MIMEBody) View(opts *ViewOptions) *MessageView {
+	if opts == nil {
+		opts = &ViewOptions{}
+	}
+	v := &MessageView{
+		Subject: m.GetHeader("Subject"),
+		From:    m.addressList("From"),
+		ReplyTo: m.addressList("Reply-To"),
+		To:      m.addressList("To"),
+		Cc:      m.addressList("Cc"),
+		Text:    m.Text,
+	}
+	v.Date, _ = m.header.Date()
+
+	shown := make(map[MIMEPart]bool)
+	if m.Html != "" {
+		v.HTML = m.resolveCIDs(opts, shown)
+	}
+
+	for _, f := range m.attachmentFiles() {
+		if shown[f.part] {
+			continue
+		}
+		a := AttachmentView{Name: f.name, ContentType: f.part.ContentType(), Size: f.Size(),
+			DisplaySize: formatSize(f.Size()), Part: f.part}
+		if opts.PartURL != nil {
+			a.URL = opts.PartURL(f.part)
+		}
+		v.Attachments = append(v.Attachments, a)
+	}
+	return v
+}
+
+// addressList decodes the address list field name, returning its decoded text as a single
+// name if it cannot be parsed
+func (m *MIMEBody) addressList(name string) AddressList {
+	value := m.header.Get(name)
+	if value == "" {
+		return nil
+	}
+	addrs, err := mail.ParseAddressList(value)
+	if err != nil {
+		return AddressList{{Name: decodeHeader(value)}}
+	}
+	list := make(AddressList, len(addrs))
+	for i, a := range addrs {
+		list[i] = AddressView{Name: decodeHeader(a.Name), Address: a.Address}
+	}
+	return list
+}
+
+// resolveCIDs returns the HTML body with its cid: references replaced by URLs of the parts they
+// name, recording the parts in shown.  References to unknown parts are left as they are.
+func (m *MIMEBody) resolveCIDs(opts *ViewOptions, shown map[MIMEPart]bool) string {
+	parts := make(map[string]MIMEPart)
+	if m.Root != nil {
+		DepthMatchAll(m.Root, func(p MIMEPart) bool {
+			if cid := strings.Trim(strings.TrimSpace(p.Header().Get("Content-Id")), "<>"); cid != "" {
+				parts[cid] = p
+			}
+			return false
+		})
+	}
+	if len(parts) == 0 {
+		return m.Html
+	}
+
+	isCID := func(u string) bool { return strings.HasPrefix(strings.ToLower(u), "cid:") }
+	return rewriteRefs(m.Html, htmlRefs(m.Html, isCID), func(ref RemoteRef) string {
+		cid := ref.URL[len("cid:"):]
+		if unescaped, err := url.PathUnescape(cid); err == nil {
+			cid = unescaped
+		}
+		p := parts[cid]
+		if p == nil {
+			return ref.URL
+		}
+		shown[p] = true
+		if opts.PartURL != nil {
+			return opts.PartURL(p)
+		}
+		return "data:" + p.ContentType() + ";base64," +
+			base64.StdEncoding.EncodeToString(p.Content())
+	})
+}
+
+// formatSize formats a size in bytes for display
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%v bytes", size)
+	}
+	f := float64(size)
+	unit := ""
+	for _, unit = range []string{"KB", "MB", "GB", "TB"} {
+		f /= 1024
+		if f < 1024 {
+			break
+		}
+	}
+	if f < 10 {
+		return fmt.Sprintf("%.1f %v", f, unit)
+	}
+	return fmt.Sprintf("%.0f %v", f, unit)
+}
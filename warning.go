@@ -0,0 +1,56 @@
+package enmime
+
+import (
+	"fmt"
+)
+
+// WarningCategory classifies the anomalies the parser recovers from.
+type WarningCategory string
+
+const (
+	// WarnUnknownCharset means text declared a character set that could not be converted
+	WarnUnknownCharset WarningCategory = "unknown-charset"
+	// WarnHeaderDecode means an RFC 2047 encoded-word could not be decoded and was kept as is
+	WarnHeaderDecode WarningCategory = "header-decode"
+	// WarnBoundaryClose means a multipart was not closed with a terminating "--" boundary
+	WarnBoundaryClose WarningCategory = "boundary-close"
+	// WarnBoundaryReuse means a nested multipart declared the boundary of an enclosing one
+	WarnBoundaryReuse WarningCategory = "boundary-reuse"
+	// WarnPartDecode means the content of a part could not be decoded, see MIMEPart.Error
+	WarnPartDecode WarningCategory = "part-decode"
+	// WarnMalformedHeader means an optional header could not be parsed and was ignored
+	WarnMalformedHeader WarningCategory = "malformed-header"
+)
+
+// Warning describes a recoverable anomaly the parser worked around.
+type Warning struct {
+	Category WarningCategory // Kind of anomaly
+	Header   string          // Name of the header involved, if any
+	Value    string          // The offending value
+	Message  string          // Description of the problem
+}
+
+// String formats the warning for logging
+func (w Warning) String() string {
+	if w.Header != "" {
+		return fmt.Sprintf("%v: %v: %v (%q)", w.Category, w.Header, w.Message, w.Value)
+	}
+	return fmt.Sprintf("%v: %v (%q)", w.Category, w.Message, w.Value)
+}
+
+// warn records a warning and passes it to the OnWarning callback
+func (pr *parser) warn(w Warning) {
+	pr.warnings = append(pr.warnings, w)
+	if pr.opts.OnWarning != nil {
+		pr.opts.OnWarning(w)
+	}
+}
+
+// decodeHeader decodes the RFC 2047 encoded-words in value, which came from the named header,
+// reporting any that could not be decoded
+func (pr *parser) decodeHeader(name, value string) string {
+	return decodeHeaderWarn(value, func(w Warning) {
+		w.Header = name
+		pr.warn(w)
+	})
+}